@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/heyLu/mu"
+	"github.com/heyLu/mu/connection"
+	"html/template"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"notes/renderable"
+)
+
+// backupTimeFormat is the format of the time in the names of backups.
+const backupTimeFormat = "20060102T150405Z"
+
+var backupNameRe = regexp.MustCompile(`^notes-(\d{8}T\d{6}Z)\.jsonl$`)
+
+type backupConfig struct {
+	dir      string
+	interval time.Duration
+	// keepDaily and keepWeekly are the number of days and weeks for
+	// which the last backup is kept.
+	keepDaily  int
+	keepWeekly int
+}
+
+// BackupStatus is the state of the backups shown on /admin/status.
+type BackupStatus struct {
+	Enabled     bool          `json:"enabled"`
+	Dir         string        `json:"dir,omitempty"`
+	Interval    time.Duration `json:"interval,omitempty"`
+	LastRun     time.Time     `json:"lastRun,omitempty"`
+	LastSuccess time.Time     `json:"lastSuccess,omitempty"`
+	LastFile    string        `json:"lastFile,omitempty"`
+	Datoms      int           `json:"datoms,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	Next        time.Time     `json:"next,omitempty"`
+	Backups     int           `json:"backups"`
+}
+
+var backupStatus struct {
+	sync.Mutex
+	BackupStatus
+}
+
+// runBackups dumps the database to config.dir every config.interval,
+// keeping the transactions of the datoms, and removes the backups that are
+// not kept anymore.  The first backup is made once the interval has passed
+// since the last existing one.
+func runBackups(conn connection.Connection, config backupConfig) {
+	err := os.MkdirAll(config.dir, 0755)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: backup:", err)
+	}
+
+	next := time.Now()
+	backups, _ := listBackups(config.dir)
+	if len(backups) > 0 {
+		next = backups[0].time.Add(config.interval)
+	}
+
+	backupStatus.Lock()
+	backupStatus.Enabled = true
+	backupStatus.Dir = config.dir
+	backupStatus.Interval = config.interval
+	backupStatus.Backups = len(backups)
+	if len(backups) > 0 {
+		backupStatus.LastSuccess = backups[0].time
+		backupStatus.LastFile = backups[0].path
+	}
+	backupStatus.Unlock()
+
+	for {
+		backupStatus.Lock()
+		backupStatus.Next = next
+		backupStatus.Unlock()
+		time.Sleep(next.Sub(time.Now()))
+
+		now := time.Now()
+		path, datoms, err := backupDatabase(conn, config, now)
+
+		backupStatus.Lock()
+		backupStatus.LastRun = now
+		backupStatus.Error = ""
+		if err != nil {
+			backupStatus.Error = err.Error()
+			fmt.Fprintln(os.Stderr, "Error: backup:", err)
+		} else {
+			backupStatus.LastSuccess = now
+			backupStatus.LastFile = path
+			backupStatus.Datoms = datoms
+		}
+		backups, _ := listBackups(config.dir)
+		backupStatus.Backups = len(backups)
+		backupStatus.Unlock()
+
+		next = now.Add(config.interval)
+	}
+}
+
+// backupDatabase writes a backup and rotates the existing ones.
+func backupDatabase(conn connection.Connection, config backupConfig, now time.Time) (string, int, error) {
+	path := filepath.Join(config.dir, "notes-"+now.UTC().Format(backupTimeFormat)+".jsonl")
+	// written to a temporary file first, so that an interrupted backup is
+	// not mistaken for a complete one
+	f, err := ioutil.TempFile(config.dir, ".backup-")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	datoms, err := dumpDatabase(w, true, conn.Db())
+	if err != nil {
+		return "", 0, err
+	}
+	err = w.Flush()
+	if err != nil {
+		return "", 0, err
+	}
+	err = f.Close()
+	if err != nil {
+		return "", 0, err
+	}
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return "", 0, err
+	}
+	fmt.Println("backup: wrote", datoms, "datoms to", path)
+
+	return path, datoms, rotateBackups(config)
+}
+
+type backupFile struct {
+	path string
+	time time.Time
+}
+
+// listBackups returns the backups in dir, newest first.
+func listBackups(dir string) ([]backupFile, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]backupFile, 0)
+	for _, fi := range files {
+		m := backupNameRe.FindStringSubmatch(fi.Name())
+		if m == nil {
+			continue
+		}
+		t, err := time.Parse(backupTimeFormat, m[1])
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backupFile{path: filepath.Join(dir, fi.Name()), time: t})
+	}
+	sort.Sort(sort.Reverse(backupsByTime(backups)))
+	return backups, nil
+}
+
+type backupsByTime []backupFile
+
+func (b backupsByTime) Len() int           { return len(b) }
+func (b backupsByTime) Less(i, j int) bool { return b[i].time.Before(b[j].time) }
+func (b backupsByTime) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// rotateBackups removes all backups except the last one of each of the
+// last config.keepDaily days and config.keepWeekly weeks.  The newest
+// backup is always kept.
+func rotateBackups(config backupConfig) error {
+	backups, err := listBackups(config.dir)
+	if err != nil {
+		return err
+	}
+
+	days := map[string]bool{}
+	weeks := map[string]bool{}
+	for i, backup := range backups {
+		t := backup.time.Local()
+		day := t.Format("2006-01-02")
+		year, week := t.ISOWeek()
+		weekKey := fmt.Sprintf("%d-%d", year, week)
+
+		keep := i == 0
+		if !days[day] && len(days) < config.keepDaily {
+			days[day] = true
+			keep = true
+		}
+		if !weeks[weekKey] && len(weeks) < config.keepWeekly {
+			weeks[weekKey] = true
+			keep = true
+		}
+		if keep {
+			continue
+		}
+
+		err = os.Remove(backup.path)
+		if err != nil {
+			return err
+		}
+		fmt.Println("backup: removed", backup.path)
+	}
+	return nil
+}
+
+// serverStatus is shown on /admin/status.
+type serverStatus struct {
+	Notes  int          `json:"notes"`
+	Tags   int          `json:"tags"`
+	Backup BackupStatus `json:"backup"`
+}
+
+// GetStatus shows the number of notes and tags and the state of the
+// backups.  The status includes the backup directory and error messages,
+// so it is only shown to clients on the same machine.
+func GetStatus(w http.ResponseWriter, req *http.Request) (interface{}, error) {
+	if !isLocalRequest(req) {
+		return renderable.RenderableStatus(http.StatusForbidden), nil
+	}
+
+	db := serverConfig.conn.Db()
+	status := serverStatus{}
+	iter := db.Aevt().Datoms2(mu.Keyword("note", "id"), nil, nil)
+	for datom := iter.Next(); datom != nil; datom = iter.Next() {
+		status.Notes += 1
+	}
+	iter = db.Aevt().Datoms2(mu.Keyword("tag", "name"), nil, nil)
+	for datom := iter.Next(); datom != nil; datom = iter.Next() {
+		status.Tags += 1
+	}
+
+	backupStatus.Lock()
+	status.Backup = backupStatus.BackupStatus
+	backupStatus.Unlock()
+
+	_, contentType := contentTypeFromExtension(req.URL.Path)
+	return renderable.Renderable{
+		Metadata:    map[string]interface{}{"Title": "Status"},
+		Data:        status,
+		Template:    statusTemplate,
+		ContentType: contentType,
+	}, nil
+}
+
+// isLocalRequest returns whether req comes from the loopback interface and
+// was not forwarded by a proxy.
+func isLocalRequest(req *http.Request) bool {
+	if req.Header.Get("X-Forwarded-For") != "" || req.Header.Get("Forwarded") != "" {
+		return false
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+var statusTemplate = template.Must(template.New("").Funcs(templateFuncs).Parse(statusTemplateStr))
+var statusTemplateStr = `<!doctype html>
+<html>
+	<head>
+		<meta charset="utf-8" />
+		<title>{{ .Metadata.Title }}</title>
+	</head>
+
+	<body>
+		<h1>{{ .Metadata.Title }}</h1>
+
+		<p>{{ .Data.Notes }} notes, {{ .Data.Tags }} tags</p>
+
+		<h2>Backups</h2>
+		{{ with .Data.Backup }}
+		{{ if .Enabled }}
+		<dl>
+			<dt>Directory</dt>
+			<dd>{{ .Dir }} ({{ .Backups }} backups, every {{ .Interval }})</dd>
+			<dt>Last backup</dt>
+			<dd>{{ if .LastSuccess.IsZero }}never{{ else }}{{ .LastSuccess.Format "2006-01-02 15:04:05" }}: {{ .LastFile }}{{ if .Datoms }} ({{ .Datoms }} datoms){{ end }}{{ end }}</dd>
+			{{ if .Error }}
+			<dt>Last error</dt>
+			<dd class="error">{{ .LastRun.Format "2006-01-02 15:04:05" }}: {{ .Error }}</dd>
+			{{ end }}
+			<dt>Next backup</dt>
+			<dd>{{ .Next.Format "2006-01-02 15:04:05" }}</dd>
+		</dl>
+		{{ else }}
+		<p>Backups are disabled, start the server with <code>-backup-dir</code> to enable them.</p>
+		{{ end }}
+		{{ end }}
+	</body>
+</html>
+`
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/heyLu/mu"
+	"github.com/heyLu/mu/connection"
+	"github.com/heyLu/mu/database"
+	tx "github.com/heyLu/mu/transactor"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dumpVersion is the version of the dump format written by DumpDatabase.
+const dumpVersion = 1
+
+// A dump is a JSON lines file: a dumpHeader with the schema, one
+// dumpDatom per line and a dumpTrailer with the number of datoms, so that
+// truncated dumps are detected.
+type dumpHeader struct {
+	Version int       `json:"version"`
+	Date    time.Time `json:"date"`
+	Schema  string    `json:"schema"`
+	// Transactions is whether the datoms record the transaction they
+	// were asserted in.
+	Transactions bool `json:"transactions,omitempty"`
+}
+
+type dumpDatom struct {
+	E  int             `json:"e"`
+	A  string          `json:"a"`
+	V  json.RawMessage `json:"v"`
+	Tx int             `json:"tx,omitempty"`
+}
+
+type dumpTrailer struct {
+	Datoms int `json:"datoms"`
+}
+
+// schemaAttribute is an attribute defined in the schema.
+type schemaAttribute struct {
+	Ident     database.Keyword
+	ValueType string
+	Unique    bool
+	// EDN is the definition of the attribute in the schema.
+	EDN string
+}
+
+func (a schemaAttribute) String() string {
+	return a.Ident.Namespace + "/" + a.Ident.Name
+}
+
+var (
+	schemaMapRe       = regexp.MustCompile(`(?s)\{([^{}]*)\}`)
+	schemaIdentRe     = regexp.MustCompile(`:db/ident\s+:([^\s/]+)/(\S+)`)
+	schemaValueTypeRe = regexp.MustCompile(`:db/valueType\s+:db\.type/(\S+)`)
+	schemaUniqueRe    = regexp.MustCompile(`:db/unique\s+:db\.unique/`)
+	schemaCommentRe   = regexp.MustCompile(`;[^\n]*`)
+)
+
+// parseSchema returns the attributes defined in the schema in the EDN
+// format used by schema.edn.
+func parseSchema(schema string) []schemaAttribute {
+	schema = schemaCommentRe.ReplaceAllString(schema, "")
+
+	var attributes []schemaAttribute
+	for _, m := range schemaMapRe.FindAllStringSubmatch(schema, -1) {
+		ident := schemaIdentRe.FindStringSubmatch(m[1])
+		valueType := schemaValueTypeRe.FindStringSubmatch(m[1])
+		if ident == nil || valueType == nil {
+			continue
+		}
+		attributes = append(attributes, schemaAttribute{
+			Ident:     mu.Keyword(ident[1], ident[2]),
+			ValueType: valueType[1],
+			Unique:    schemaUniqueRe.MatchString(m[1]),
+			EDN:       m[0],
+		})
+	}
+	return attributes
+}
+
+// missingSchema returns the definitions of the attributes in schema that
+// are not installed in db, or "" if there are none.
+func missingSchema(db *database.Database, schema string) string {
+	var missing []string
+	for _, attribute := range parseSchema(schema) {
+		if db.Entid(attribute.Ident) == -1 {
+			missing = append(missing, attribute.EDN)
+		}
+	}
+	if len(missing) == 0 {
+		return ""
+	}
+	return "[" + strings.Join(missing, "\n ") + "]"
+}
+
+// readSchema reads the schema new databases are created with.
+func readSchema() (string, error) {
+	data, err := ioutil.ReadFile("schema.edn")
+	return string(data), err
+}
+
+// databaseSchema returns the definitions of the attributes installed in
+// db, in the format of schema.edn.  The attributes of mu itself are left
+// out.
+func databaseSchema(db *database.Database) string {
+	var definitions []string
+	iter := db.Aevt().Datoms2(mu.Keyword("db", "valueType"), nil, nil)
+	for datom := iter.Next(); datom != nil; datom = iter.Next() {
+		ident, ok := databaseIdent(db, datom.E())
+		if !ok || ident.Namespace == "db" || strings.HasPrefix(ident.Namespace, "db.") || ident.Namespace == "fressian" {
+			continue
+		}
+		valueType, ok := databaseIdent(db, datom.V().Val())
+		if !ok {
+			continue
+		}
+
+		entity := db.Entity(datom.E())
+		lines := []string{
+			"{:db/id #db/id[:db.part/db]",
+			"  :db/ident :" + ident.Namespace + "/" + ident.Name,
+		}
+		if doc, ok := entity.Get(mu.Keyword("db", "doc")).(string); ok {
+			lines = append(lines, "  :db/doc "+strconv.Quote(doc))
+		}
+		lines = append(lines, "  :db/valueType :"+valueType.Namespace+"/"+valueType.Name)
+		cardinality, ok := databaseIdent(db, entity.Get(mu.Keyword("db", "cardinality")))
+		if !ok {
+			cardinality = mu.Keyword("db.cardinality", "one")
+		}
+		lines = append(lines, "  :db/cardinality :"+cardinality.Namespace+"/"+cardinality.Name)
+		if unique, ok := databaseIdent(db, entity.Get(mu.Keyword("db", "unique"))); ok {
+			lines = append(lines, "  :db/unique :"+unique.Namespace+"/"+unique.Name)
+		}
+		if index, _ := entity.Get(mu.Keyword("db", "index")).(bool); index {
+			lines = append(lines, "  :db/index true")
+		}
+		lines = append(lines, "  :db.install/_attribute :db.part/db}")
+		definitions = append(definitions, strings.Join(lines, "\n "))
+	}
+	return "[" + strings.Join(definitions, "\n ") + "]\n"
+}
+
+// databaseIdent returns the ident of v, which is an entity id or an ident
+// already.
+func databaseIdent(db *database.Database, v interface{}) (database.Keyword, bool) {
+	switch v := v.(type) {
+	case database.Keyword:
+		return v, true
+	case int:
+		ident, ok := db.Entity(v).Get(mu.Keyword("db", "ident")).(database.Keyword)
+		return ident, ok
+	default:
+		return database.Keyword{}, false
+	}
+}
+
+// DumpDatabase writes the schema of the database and all its current
+// datoms to path.
+//
+// With transactions, each datom records the transaction it was asserted
+// in, so that restoring it transacts the datoms in the same groups and
+// order.  This is not the history of the database: mu does not give
+// access to retracted values, so the dump never contains them, nor the
+// earlier revisions of changed notes.
+func DumpDatabase(path string, transactions bool, conn connection.Connection) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	n, err := dumpDatabase(w, transactions, conn.Db())
+	if err != nil {
+		return err
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("dumped", n, "datoms")
+	return nil
+}
+
+func dumpDatabase(w io.Writer, transactions bool, db *database.Database) (int, error) {
+	schema := databaseSchema(db)
+	encoder := json.NewEncoder(w)
+	err := encoder.Encode(dumpHeader{
+		Version:      dumpVersion,
+		Date:         time.Now(),
+		Schema:       schema,
+		Transactions: transactions,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, attribute := range parseSchema(schema) {
+		iter := db.Aevt().Datoms2(attribute.Ident, nil, nil)
+		for datom := iter.Next(); datom != nil; datom = iter.Next() {
+			v, err := encodeDumpValue(attribute, datom.V().Val())
+			if err != nil {
+				return n, fmt.Errorf("%s of %d: %s", attribute, datom.E(), err)
+			}
+
+			d := dumpDatom{E: datom.E(), A: attribute.String(), V: v}
+			if transactions {
+				d.Tx = datom.Tx()
+			}
+			err = encoder.Encode(d)
+			if err != nil {
+				return n, err
+			}
+			n += 1
+		}
+	}
+
+	return n, encoder.Encode(dumpTrailer{Datoms: n})
+}
+
+func encodeDumpValue(attribute schemaAttribute, val interface{}) (json.RawMessage, error) {
+	switch attribute.ValueType {
+	case "string", "boolean", "long", "double", "float":
+		return json.Marshal(val)
+	case "instant":
+		t, ok := val.(time.Time)
+		if !ok {
+			return nil, fmt.Errorf("unexpected instant %T", val)
+		}
+		return json.Marshal(t.Format(time.RFC3339Nano))
+	case "uri":
+		return json.Marshal(fmt.Sprint(val))
+	case "ref":
+		id, ok := val.(int)
+		if !ok {
+			return nil, fmt.Errorf("unexpected ref %T", val)
+		}
+		return json.Marshal(id)
+	default:
+		return nil, fmt.Errorf("unsupported value type %s", attribute.ValueType)
+	}
+}
+
+func decodeDumpValue(attribute schemaAttribute, raw json.RawMessage) (interface{}, error) {
+	var err error
+	switch attribute.ValueType {
+	case "string":
+		var s string
+		err = json.Unmarshal(raw, &s)
+		return s, err
+	case "boolean":
+		var b bool
+		err = json.Unmarshal(raw, &b)
+		return b, err
+	case "long", "ref":
+		var i int
+		err = json.Unmarshal(raw, &i)
+		return i, err
+	case "double", "float":
+		var f float64
+		err = json.Unmarshal(raw, &f)
+		return f, err
+	case "instant":
+		var s string
+		err = json.Unmarshal(raw, &s)
+		if err != nil {
+			return nil, err
+		}
+		return time.Parse(time.RFC3339Nano, s)
+	case "uri":
+		var s string
+		err = json.Unmarshal(raw, &s)
+		if err != nil {
+			return nil, err
+		}
+		return url.Parse(s)
+	default:
+		return nil, fmt.Errorf("unsupported value type %s", attribute.ValueType)
+	}
+}
+
+// dump is a dump read into memory.
+type dump struct {
+	dumpHeader
+	attributes map[string]schemaAttribute
+	datoms     []dumpDatom
+}
+
+func readDump(r io.Reader) (*dump, error) {
+	decoder := json.NewDecoder(bufio.NewReader(r))
+
+	d := &dump{attributes: map[string]schemaAttribute{}}
+	err := decoder.Decode(&d.dumpHeader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dump header: %s", err)
+	}
+	if d.Version != dumpVersion {
+		return nil, fmt.Errorf("unsupported dump version %d", d.Version)
+	}
+	for _, attribute := range parseSchema(d.Schema) {
+		d.attributes[attribute.String()] = attribute
+	}
+
+	for {
+		var line struct {
+			dumpDatom
+			Datoms *int `json:"datoms"`
+		}
+		err = decoder.Decode(&line)
+		if err == io.EOF {
+			return nil, fmt.Errorf("truncated dump, read %d datoms", len(d.datoms))
+		} else if err != nil {
+			return nil, fmt.Errorf("datom %d: %s", len(d.datoms)+1, err)
+		}
+
+		if line.Datoms != nil {
+			if *line.Datoms != len(d.datoms) {
+				return nil, fmt.Errorf("expected %d datoms, but read %d", *line.Datoms, len(d.datoms))
+			}
+			return d, nil
+		}
+		if _, ok := d.attributes[line.A]; !ok {
+			return nil, fmt.Errorf("datom %d: attribute %s is not in the schema", len(d.datoms)+1, line.A)
+		}
+		d.datoms = append(d.datoms, line.dumpDatom)
+	}
+}
+
+// RestoreDatabase creates the database at dbUrl with the schema and datoms
+// in the dump at path, and verifies that it contains all datoms.
+//
+// The database must not exist yet.  Entity ids are not kept, but all
+// references between entities are.
+func RestoreDatabase(path string, dbUrl string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	d, err := readDump(f)
+	if err != nil {
+		return err
+	}
+
+	_, txs, err := restoreDatabase(d, dbUrl)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("restored", len(d.datoms), "datoms in", txs, "transactions")
+	return nil
+}
+
+// restoreDatabase creates the database at dbUrl from d and verifies it.
+//
+// Existing databases are left alone, without installing the schema of the
+// dump into them.
+func restoreDatabase(d *dump, dbUrl string) (connection.Connection, int, error) {
+	isNew, err := mu.CreateDatabase(dbUrl)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !isNew {
+		return nil, 0, fmt.Errorf("database %s exists already, restore needs a new database", dbUrl)
+	}
+
+	conn, err := mu.Connect(dbUrl)
+	if err != nil {
+		return nil, 0, err
+	}
+	_, err = mu.TransactString(conn, d.Schema)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	txs, err := restoreDump(conn, d)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = verifyDump(conn.Db(), d)
+	if err != nil {
+		return nil, 0, fmt.Errorf("verifying %s: %s", dbUrl, err)
+	}
+	return conn, txs, nil
+}
+
+// restoreDump transacts the datoms of d, in the transactions they were
+// asserted in for dumps with transactions, and in one transaction
+// otherwise.
+func restoreDump(conn connection.Connection, d *dump) (int, error) {
+	groups := map[int][]dumpDatom{}
+	for _, datom := range d.datoms {
+		groups[datom.Tx] = append(groups[datom.Tx], datom)
+	}
+	txIds := make([]int, 0, len(groups))
+	for txId := range groups {
+		txIds = append(txIds, txId)
+	}
+	sort.Ints(txIds)
+
+	// unique maps the entities of the dump to a unique attribute and
+	// value, so that transactions can refer to entities created in
+	// earlier ones.
+	unique := map[int]database.HasLookup{}
+	for _, datom := range d.datoms {
+		if attribute := d.attributes[datom.A]; attribute.Unique {
+			v, err := decodeDumpValue(attribute, datom.V)
+			if err != nil {
+				return 0, err
+			}
+			unique[datom.E] = mu.LookupRef(attribute.Ident, v)
+		}
+	}
+
+	restored := map[int]bool{}
+	for _, txId := range txIds {
+		tempids := map[int]int{}
+		tempid := 0
+		entity := func(e int) (database.HasLookup, error) {
+			if id, ok := tempids[e]; ok {
+				return mu.Id(id), nil
+			}
+			if restored[e] {
+				if lookup, ok := unique[e]; ok {
+					return lookup, nil
+				}
+				return nil, fmt.Errorf("entity %d is changed in several transactions, but has no unique attribute", e)
+			}
+			tempid -= 1
+			tempids[e] = mu.Tempid(mu.DbPartUser, tempid)
+			return mu.Id(tempids[e]), nil
+		}
+
+		txData := make([]tx.TxDatum, 0, len(groups[txId]))
+		for _, datom := range groups[txId] {
+			attribute := d.attributes[datom.A]
+			v, err := decodeDumpValue(attribute, datom.V)
+			if err != nil {
+				return 0, fmt.Errorf("%s of %d: %s", datom.A, datom.E, err)
+			}
+			if attribute.ValueType == "ref" {
+				v, err = entity(v.(int))
+				if err != nil {
+					return 0, err
+				}
+			}
+
+			e, err := entity(datom.E)
+			if err != nil {
+				return 0, err
+			}
+			txData = append(txData, tx.Datum{Op: tx.Assert, E: e, A: attribute.Ident, V: tx.NewValue(v)})
+		}
+
+		_, err := mu.Transact(conn, txData)
+		if err != nil {
+			return 0, err
+		}
+		for e := range tempids {
+			restored[e] = true
+		}
+	}
+
+	return len(txIds), nil
+}
+
+// verifyDump checks that db has as many datoms for each attribute as the
+// dump.
+func verifyDump(db *database.Database, d *dump) error {
+	expected := map[string]int{}
+	for _, datom := range d.datoms {
+		expected[datom.A] += 1
+	}
+
+	names := make([]string, 0, len(expected))
+	for name := range expected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var mismatches []string
+	for _, name := range names {
+		n := 0
+		iter := db.Aevt().Datoms2(d.attributes[name].Ident, nil, nil)
+		for datom := iter.Next(); datom != nil; datom = iter.Next() {
+			n += 1
+		}
+		if n != expected[name] {
+			mismatches = append(mismatches, fmt.Sprintf("%s: expected %d datoms, got %d", name, expected[name], n))
+		}
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%s", strings.Join(mismatches, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/heyLu/mu/connection"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// bibBlockRe matches the BibTeX entry import-bibtex stores in the content.
+var bibBlockRe = regexp.MustCompile("(?s)```bibtex\n(.*?)\n```")
+
+var bibEscaper = strings.NewReplacer(
+	`\`, `\textbackslash{}`, "{", `\{`, "}", `\}`,
+	"&", `\&`, "%", `\%`, "$", `\$`, "#", `\#`, "_", `\_`,
+)
+
+// ExportToBibTeX writes notes as BibTeX entries.  If tag is empty, the
+// notes imported from BibTeX are exported, otherwise all notes with that
+// tag.
+func ExportToBibTeX(path string, tag string, conn connection.Connection) error {
+	posts, err := bibPosts(conn, tag)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = writeBibTeX(f, posts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("exported", len(posts), "entries")
+	return nil
+}
+
+// GetBibTeX serves the notes as a BibTeX file, like ExportToBibTeX with
+// the tag given in the `tag` query parameter.
+func GetBibTeX(w http.ResponseWriter, req *http.Request) {
+	posts, err := bibPosts(serverConfig.conn, req.URL.Query().Get("tag"))
+	if err != nil {
+		status := http.StatusNotFound
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-bibtex; charset=UTF-8")
+	err = writeBibTeX(w, posts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: rendering bibtex:", err)
+	}
+}
+
+func bibPosts(conn connection.Connection, tag string) ([]Post, error) {
+	db := conn.Db()
+	if tag != "" {
+		posts, ok := TaggedPosts(db, tag)
+		if !ok {
+			return nil, fmt.Errorf("no such tag '%s'", tag)
+		}
+		return posts, nil
+	}
+
+	var posts []Post
+	for _, post := range AllPosts(db) {
+		if bibBlockRe.MatchString(post.Content()) {
+			posts = append(posts, post)
+		}
+	}
+	return posts, nil
+}
+
+func writeBibTeX(w io.Writer, posts []Post) error {
+	for i, post := range posts {
+		if i > 0 {
+			_, err := io.WriteString(w, "\n")
+			if err != nil {
+				return err
+			}
+		}
+		_, err := io.WriteString(w, bibEntryFor(post))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bibEntryFor returns the entry for post: the one it was imported from,
+// or a @misc entry with its title, url, date and tags.
+func bibEntryFor(post Post) string {
+	if m := bibBlockRe.FindStringSubmatch(post.Content()); m != nil {
+		return m[1] + "\n"
+	}
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "@misc{%s,\n", post.Id())
+	fmt.Fprintf(buf, "  title = {%s},\n", bibEscaper.Replace(post.Title()))
+	if u := post.URL(); u != nil {
+		// the url field is read verbatim
+		fmt.Fprintf(buf, "  url = {%s},\n", u.String())
+		fmt.Fprintf(buf, "  urldate = {%s},\n", post.Date().Format("2006-01-02"))
+	}
+	fmt.Fprintf(buf, "  year = {%d},\n", post.Date().Year())
+	fmt.Fprintf(buf, "  month = {%d},\n", int(post.Date().Month()))
+	if tags := post.Tags(); len(tags) > 0 {
+		names := make([]string, len(tags))
+		for i, tag := range tags {
+			names[i] = bibEscaper.Replace(tag.Name())
+		}
+		fmt.Fprintf(buf, "  keywords = {%s},\n", strings.Join(names, ", "))
+	}
+	fmt.Fprintf(buf, "}\n")
+	return buf.String()
+}
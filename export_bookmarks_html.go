@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"github.com/heyLu/mu/connection"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+)
+
+// ExportToBookmarksHTML writes the notes with a url as a Netscape bookmark
+// file that browsers can import.  If tag is not empty, only notes with
+// that tag are exported, in a folder named after the tag.
+func ExportToBookmarksHTML(path string, tag string, conn connection.Connection) error {
+	posts, err := bookmarkPosts(conn, tag)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = writeBookmarksHTML(f, tag, posts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("exported", len(posts), "bookmarks")
+	return nil
+}
+
+// GetBookmarksHTML serves the bookmarks as a download, optionally
+// restricted to the tag given in the `tag` query parameter.
+func GetBookmarksHTML(w http.ResponseWriter, req *http.Request) {
+	tag := req.URL.Query().Get("tag")
+	posts, err := bookmarkPosts(serverConfig.conn, tag)
+	if err != nil {
+		status := http.StatusNotFound
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"bookmarks.html\"")
+	err = writeBookmarksHTML(w, tag, posts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: rendering bookmarks:", err)
+	}
+}
+
+// bookmarkPosts returns the notes with a url, all of them or those tagged
+// with tag.
+func bookmarkPosts(conn connection.Connection, tag string) ([]Post, error) {
+	db := conn.Db()
+
+	var posts []Post
+	if tag == "" {
+		posts = AllPosts(db)
+	} else {
+		var ok bool
+		posts, ok = TaggedPosts(db, tag)
+		if !ok {
+			return nil, fmt.Errorf("no such tag '%s'", tag)
+		}
+	}
+
+	bookmarks := make([]Post, 0, len(posts))
+	for _, post := range posts {
+		if post.URL() != nil {
+			bookmarks = append(bookmarks, post)
+		}
+	}
+	return bookmarks, nil
+}
+
+func writeBookmarksHTML(w io.Writer, folder string, posts []Post) error {
+	return bookmarksHTMLTemplate.Execute(w, map[string]interface{}{
+		"Folder": folder,
+		"Posts":  posts,
+	})
+}
+
+var bookmarksHTMLTemplate = template.Must(template.New("").Funcs(templateFuncs).Parse(bookmarksHTMLTemplateStr))
+var bookmarksHTMLTemplateStr = `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+{{- if .Folder }}
+<DT><H3>{{ .Folder }}</H3>
+<DL><p>
+{{- end }}
+{{- range .Posts }}
+<DT><A HREF="{{ .URL }}" ADD_DATE="{{ .Date.Unix }}"{{ if .Tags }} TAGS="{{ join .Tags "," }}"{{ end }}{{ if .Private }} PRIVATE="1"{{ end }}{{ if .ToRead }} TOREAD="1"{{ end }}>{{ .Title }}</A>
+{{- if .Content }}
+<DD>{{ .Content }}
+{{- end }}
+{{- end }}
+{{- if .Folder }}
+</DL><p>
+{{- end }}
+</DL><p>
+`
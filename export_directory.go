@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/heyLu/mu/connection"
+	"github.com/heyLu/mu/database"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// noteFileNameRe matches the characters that are replaced in file names.
+var noteFileNameRe = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// ExportToDirectory writes one Markdown file per note to directory.
+//
+// The files are named after the note id (see noteFileName) and are only
+// written if their content changed, so that the directory can be kept in
+// version control.  The files of notes that were removed or renamed are
+// removed.
+func ExportToDirectory(directory string, conn connection.Connection) error {
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		return err
+	}
+
+	db := conn.Db()
+	written, unchanged := 0, 0
+	for _, post := range AllPosts(db) {
+		changed, err := writeNoteFile(directory, post)
+		if err != nil {
+			return err
+		}
+
+		if changed {
+			written += 1
+		} else {
+			unchanged += 1
+		}
+	}
+
+	removed, err := removeStaleNoteFiles(directory, db)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("wrote", written, "notes,", unchanged, "unchanged,", removed, "removed")
+	return nil
+}
+
+// removeStaleNoteFiles removes the files in directory with the id of a
+// note that does not exist, or that is written to a different file.
+// Other files are left alone.
+func removeStaleNoteFiles(directory string, db *database.Database) (int, error) {
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, fi := range files {
+		if fi.IsDir() || path.Ext(fi.Name()) != ".md" {
+			continue
+		}
+
+		p := path.Join(directory, fi.Name())
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return removed, err
+		}
+		fm, _, err := parseFrontMatter(string(data))
+		if err != nil || fm.String("id") == "" {
+			continue
+		}
+		if noteId := findNote(db, fm.String("id")); noteId != -1 && noteFileName(Post{db.Entity(noteId)}) == fi.Name() {
+			continue
+		}
+
+		err = os.Remove(p)
+		if err != nil {
+			return removed, err
+		}
+		removed += 1
+	}
+	return removed, nil
+}
+
+// noteFileName returns the name of the file of post: a slug of its id,
+// followed by a hash of the id, so that ids can never be used as paths.
+func noteFileName(post Post) string {
+	return safeName(post.Id()) + ".md"
+}
+
+// safeName returns a slug of s followed by a hash of s, which can be used
+// in file names and urls whatever s contains.
+func safeName(s string) string {
+	slug := strings.Trim(noteFileNameRe.ReplaceAllString(s, "-"), "-")
+	if len(slug) > 40 {
+		slug = slug[:40]
+	}
+
+	hash := sha1.Sum([]byte(s))
+	if slug == "" {
+		return hex.EncodeToString(hash[:4])
+	}
+	return slug + "-" + hex.EncodeToString(hash[:4])
+}
+
+// writeNoteFile writes post to its file in directory, unless the file
+// already has the same content.
+func writeNoteFile(directory string, post Post) (changed bool, err error) {
+	p := path.Join(directory, noteFileName(post))
+	data := noteFile(post)
+
+	old, err := ioutil.ReadFile(p)
+	if err == nil && bytes.Equal(old, data) {
+		return false, nil
+	}
+
+	err = ioutil.WriteFile(p, data, 0644)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// noteFile renders post as a Markdown file with a front matter block,
+// followed by the "# title\ncontent" convention used by the editor and
+// readDirectory.
+func noteFile(post Post) []byte {
+	buf := new(bytes.Buffer)
+	fmt.Fprintln(buf, "---")
+	fmt.Fprintf(buf, "id: %s\n", yamlString(post.Id()))
+	fmt.Fprintf(buf, "date: %s\n", post.Date().Format(time.RFC3339Nano))
+	if u := post.URL(); u != nil {
+		fmt.Fprintf(buf, "url: %s\n", yamlString(u.String()))
+	}
+	if tags := post.Tags(); tags != nil {
+		names := make([]string, len(tags))
+		for i, tag := range tags {
+			names[i] = yamlString(tag.Name())
+		}
+		fmt.Fprintf(buf, "tags: [%s]\n", strings.Join(names, ", "))
+	}
+	if post.ToRead() {
+		fmt.Fprintln(buf, "toread: true")
+	}
+	if post.Private() {
+		fmt.Fprintln(buf, "private: true")
+	}
+	fmt.Fprintln(buf, "---")
+	fmt.Fprintf(buf, "# %s\n%s", post.Title(), post.Content())
+	return buf.Bytes()
+}
+
+// yamlString quotes s if it would not be read back as a plain string.
+func yamlString(s string) string {
+	if s == "" || strings.TrimSpace(s) != s ||
+		strings.ContainsAny(s[0:1], "[]{}#&*!|>'\"%@`-?:") ||
+		strings.ContainsAny(s, " ,[]{}\n") ||
+		strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.HasSuffix(s, ":") {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+// noteFileName is safeName with an extension, tested without a database.
+func TestSafeName(t *testing.T) {
+	tests := []struct {
+		id, name string
+	}{
+		{"hello-world", "hello-world-"},
+		{"../../etc/passwd", "etc-passwd-"},
+		{`a\b`, "a-b-"},
+		{"DBLP:conf/nips/Vaswani17", "DBLP-conf-nips-Vaswani17-"},
+		{"////", ""},
+	}
+
+	for _, test := range tests {
+		name := safeName(test.id)
+		if len(name) != len(test.name)+8 || name[:len(test.name)] != test.name {
+			t.Errorf("%q: got %q, expected %q followed by a hash", test.id, name, test.name)
+		}
+	}
+
+	if safeName("a/b") == safeName("a:b") {
+		t.Errorf("different ids with the same slug have the same name")
+	}
+	if len(safeName(string(make([]byte, 100))+"x")) > 40+1+8 {
+		t.Errorf("long ids are not shortened")
+	}
+}
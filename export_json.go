@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/heyLu/mu/connection"
+	"os"
+)
+
+func ExportToJSON(path string, conn connection.Connection) error {
+	posts := AllPosts(conn.Db())
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := json.MarshalIndent(posts, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("exported", len(posts), "notes")
+	return nil
+}
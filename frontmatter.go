@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// frontMatter is the metadata block at the start of a note file.
+//
+// Both YAML ("---") and TOML ("+++") style blocks are understood, as long
+// as they only contain simple values and lists:
+//
+//	---
+//	title: A note
+//	date: 2016-01-02T15:04:05Z
+//	tags: [go, notes]
+//	---
+type frontMatter struct {
+	values map[string][]string
+	// lists are the keys whose value is a list or a quoted string, which
+	// List does not split.
+	lists map[string]bool
+}
+
+// parseFrontMatter splits the front matter off the start of content.  If
+// content has no front matter, fm is empty and rest is content.
+func parseFrontMatter(content string) (fm frontMatter, rest string, err error) {
+	fm = frontMatter{values: map[string][]string{}, lists: map[string]bool{}}
+
+	var delim, sep string
+	switch {
+	case strings.HasPrefix(content, "---\n"):
+		delim, sep = "---", ":"
+	case strings.HasPrefix(content, "+++\n"):
+		delim, sep = "+++", "="
+	default:
+		return fm, content, nil
+	}
+
+	lines := strings.SplitAfter(content, "\n")
+	key := ""
+	for i, line := range lines[1:] {
+		line = strings.TrimRight(line, "\r\n")
+		if line == delim {
+			rest = strings.Join(lines[i+2:], "")
+			return fm, rest, nil
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// list items on their own lines, as in
+		//  tags:
+		//    - go
+		if strings.HasPrefix(trimmed, "- ") && key != "" {
+			fm.values[key] = append(fm.values[key], unquote(strings.TrimSpace(trimmed[2:])))
+			fm.lists[key] = true
+			continue
+		}
+
+		parts := strings.SplitN(line, sep, 2)
+		if len(parts) != 2 {
+			return frontMatter{}, content, fmt.Errorf("invalid front matter line %d: %q", i+2, line)
+		}
+		key = strings.ToLower(strings.TrimSpace(parts[0]))
+		fm.values[key], fm.lists[key] = parseFrontMatterValue(strings.TrimSpace(parts[1]))
+	}
+
+	return frontMatter{}, content, fmt.Errorf("unterminated front matter")
+}
+
+// parseFrontMatterValue parses a value, and returns whether it is a list
+// or a quoted string.
+func parseFrontMatterValue(value string) ([]string, bool) {
+	if value == "" {
+		return nil, false
+	}
+
+	if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+		unquoted := unquote(value)
+		return []string{unquoted}, unquoted != value
+	}
+
+	values := make([]string, 0)
+	for _, v := range splitList(value[1 : len(value)-1]) {
+		v = unquote(strings.TrimSpace(v))
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+	return values, true
+}
+
+// splitList splits s at commas that are not inside of quotes.
+func splitList(s string) []string {
+	parts := make([]string, 0)
+	start := 0
+	var quote rune
+	escaped := false
+	for i, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && quote == '"':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 {
+		switch {
+		case s[0] == '"' && s[len(s)-1] == '"':
+			if u, err := strconv.Unquote(s); err == nil {
+				return u
+			}
+		case s[0] == '\'' && s[len(s)-1] == '\'':
+			return strings.Replace(s[1:len(s)-1], "''", "'", -1)
+		}
+	}
+	return s
+}
+
+// String returns the value of key, or "" if it is not set.
+func (fm frontMatter) String(key string) string {
+	if len(fm.values[key]) == 0 {
+		return ""
+	}
+	return fm.values[key][0]
+}
+
+// List returns the values of key.  A plain value that is not a list is
+// split at commas and spaces, so that "tags: go notes" works as well.
+func (fm frontMatter) List(key string) []string {
+	values := fm.values[key]
+	if len(values) == 1 && !fm.lists[key] {
+		return strings.FieldsFunc(values[0], func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+	}
+	return values
+}
+
+var dateFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseDate parses the date formats commonly found in front matter.
+// Dates without a time zone are interpreted as local time.
+func parseDate(s string) (time.Time, error) {
+	for _, format := range dateFormats {
+		t, err := time.ParseInLocation(format, s, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
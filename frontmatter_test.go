@@ -0,0 +1,44 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFrontMatter(t *testing.T) {
+	tests := []struct {
+		content string
+		id      string
+		tags    []string
+		rest    string
+		err     bool
+	}{
+		{"no front matter", "", nil, "no front matter", false},
+		{"---\nid: abc\ntags: [go, notes]\n---\n# Title\n", "abc", []string{"go", "notes"}, "# Title\n", false},
+		{"+++\nid = \"abc\"\ntags = [\"go\"]\n+++\ncontent", "abc", []string{"go"}, "content", false},
+		{"---\ntags:\n  - go\n  - two words\n---\n", "", []string{"go", "two words"}, "", false},
+		{"---\ntags: go notes\n---\n", "", []string{"go", "notes"}, "", false},
+		{"---\ntags: \"a, b\"\n---\n", "", []string{"a, b"}, "", false},
+		{"---\ntags: [\"a, b\", c]\n---\n", "", []string{"a, b", "c"}, "", false},
+		{"---\n# a comment\nid: 'it''s'\n---\n", "it's", nil, "", false},
+		{"---\nid: abc\n", "", nil, "", true},
+		{"---\nnot a value\n---\n", "", nil, "", true},
+	}
+
+	for _, test := range tests {
+		fm, rest, err := parseFrontMatter(test.content)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.content)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", test.content, err)
+			continue
+		}
+		if fm.String("id") != test.id || !reflect.DeepEqual(fm.List("tags"), test.tags) || rest != test.rest {
+			t.Errorf("%q: got id %q, tags %q, rest %q", test.content, fm.String("id"), fm.List("tags"), rest)
+		}
+	}
+}
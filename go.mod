@@ -0,0 +1,8 @@
+module notes
+
+go 1.25.0
+
+require (
+	github.com/golang/gddo v0.0.0-20210115222349-20d68f94ee1f
+	golang.org/x/net v0.57.0
+)
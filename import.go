@@ -0,0 +1,753 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/heyLu/mu"
+	"github.com/heyLu/mu/connection"
+	"github.com/heyLu/mu/database"
+	tx "github.com/heyLu/mu/transactor"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An Importer reads notes in one format.
+//
+// Importers only parse their input, the notes are matched against
+// existing notes, resolved to tags and transacted by Import.
+type Importer struct {
+	Description string
+	// Read reads the notes at path and passes them to notes.
+	Read func(path string, opts importOptions, notes noteSink) error
+	// Key decides how notes are matched against existing notes.
+	Key dedupeKey
+	// Policy is used for notes that exist already, unless the import
+	// specifies a different policy.
+	Policy conflictPolicy
+}
+
+// noteSink receives the notes read by an Importer.
+type noteSink interface {
+	// Add adds a note to the import.
+	Add(note importNote) error
+	// Invalid reports data at source that could not be parsed.
+	Invalid(source string, err error)
+	// Ignore reports data at source that was skipped on purpose.
+	Ignore(source string, reason string)
+	// Flush transacts the notes added so far, so that importers can
+	// keep the order of changes in their data as separate transactions.
+	Flush() error
+}
+
+var importers = map[string]Importer{}
+
+// RegisterImporter makes an importer available as the format name.
+func RegisterImporter(name string, importer Importer) {
+	if _, exists := importers[name]; exists {
+		panic("importer " + name + " registered twice")
+	}
+	importers[name] = importer
+}
+
+// ImportFormats returns the names of the registered importers.
+func ImportFormats() []string {
+	names := make([]string, 0, len(importers))
+	for name := range importers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Import imports the notes at path using the importer registered as
+// format.
+func Import(format string, path string, conn connection.Connection, opts importOptions) error {
+	importer, ok := importers[format]
+	if !ok {
+		return fmt.Errorf("unknown import format '%s'", format)
+	}
+
+	imp, err := newNoteImporter(conn, importer.Key, importer.Policy, opts)
+	if err != nil {
+		return err
+	}
+
+	err = importer.Read(path, opts, imp)
+	if err != nil {
+		return err
+	}
+
+	return imp.Commit()
+}
+
+// importNote is a note as read by one of the importers, before it is
+// turned into transaction data.
+type importNote struct {
+	Id      string // optional, generated if empty
+	Title   string
+	Content string
+	Date    time.Time
+	// Modified is the date the note was last changed. (optional)
+	Modified time.Time
+	URL      *url.URL // optional
+	Tags     []string
+	ToRead   bool
+	Private  bool
+
+	// Source is the position of the note in the imported data, used
+	// when reporting problems.
+	Source string
+}
+
+// conflictPolicy decides what happens to notes that exist already.
+type conflictPolicy int
+
+const (
+	conflictDefault conflictPolicy = iota
+	conflictSkip
+	conflictOverwrite
+	conflictMergeTags
+)
+
+func parseConflictPolicy(s string) (conflictPolicy, error) {
+	switch s {
+	case "":
+		return conflictDefault, nil
+	case "skip":
+		return conflictSkip, nil
+	case "overwrite":
+		return conflictOverwrite, nil
+	case "merge-tags":
+		return conflictMergeTags, nil
+	default:
+		return conflictDefault, fmt.Errorf("unknown conflict policy '%s'", s)
+	}
+}
+
+// dedupeKey decides how existing notes are detected.
+//
+// Notes with an explicit id are always matched by id first.
+type dedupeKey int
+
+const (
+	dedupeById dedupeKey = iota
+	dedupeByURL
+	dedupeByContent
+)
+
+type importOptions struct {
+	onConflict conflictPolicy
+	// dryRun reports what would be imported without transacting.
+	dryRun bool
+	// directoryTags adds the names of subdirectories as tags.
+	directoryTags bool
+	// kindleBooks imports one note per book instead of per highlight.
+	kindleBooks bool
+	// keepTrashed imports trashed Google Keep notes.
+	keepTrashed bool
+	// batchSize is the number of notes per transaction, 0 imports all
+	// notes in a single transaction.
+	batchSize int
+	// progressPath is where the number of committed records is stored
+	// after each batch, resume continues after them.  See
+	// importProgressPath.
+	progressPath string
+	resume       bool
+}
+
+// noteImporter collects imported notes into transactions, detecting
+// notes that exist already and resolving tags.
+type noteImporter struct {
+	conn   connection.Connection
+	db     *database.Database
+	key    dedupeKey
+	policy conflictPolicy
+	dryRun bool
+
+	// existing maps dedupe keys to entity ids, including the tempids
+	// of notes added in the current batch.  pending maps those tempids
+	// (and the ids of notes changed in the current batch) to note ids.
+	existing map[string]int
+	pending  map[int]string
+	tags     *tagIds
+	txData   []tx.TxDatum
+	tempid   int
+
+	batchSize    int
+	batchNotes   int
+	batches      int
+	progressPath string
+	// records counts the notes passed to Add and Invalid, the first
+	// skipRecords of them were committed by a previous import.
+	records     int
+	skipRecords int
+
+	created, updated, skipped int
+	newTags, datoms           int
+	// report lists what happened to each note, printed for dry runs.
+	report  []string
+	invalid []string
+	ignored []string
+}
+
+// importProgressPath returns the file the progress of importing path
+// into the database at dbUrl is recorded in.  It is in the user's cache
+// directory, so that imports from read-only locations can be resumed.
+func importProgressPath(dbUrl, format, path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "notes", "imports")
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha1.Sum([]byte(dbUrl + "\n" + format + "\n" + abs))
+	return filepath.Join(dir, hex.EncodeToString(hash[:8])+".progress"), nil
+}
+
+func newNoteImporter(conn connection.Connection, key dedupeKey, defaultPolicy conflictPolicy, opts importOptions) (*noteImporter, error) {
+	policy := opts.onConflict
+	if policy == conflictDefault {
+		policy = defaultPolicy
+	}
+
+	db := conn.Db()
+	imp := &noteImporter{
+		conn:     conn,
+		db:       db,
+		key:      key,
+		policy:   policy,
+		dryRun:   opts.dryRun,
+		existing: map[string]int{},
+		pending:  map[int]string{},
+		tags:     newTagIds(db),
+		txData:   make([]tx.TxDatum, 0),
+
+		batchSize:    opts.batchSize,
+		progressPath: opts.progressPath,
+	}
+
+	if opts.resume && opts.progressPath != "" {
+		data, err := ioutil.ReadFile(opts.progressPath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			imp.skipRecords, err = strconv.Atoi(strings.TrimSpace(string(data)))
+			if err != nil {
+				return nil, fmt.Errorf("invalid progress file %s: %s", opts.progressPath, err)
+			}
+			fmt.Println("resuming after", imp.skipRecords, "records")
+		}
+	}
+
+	switch key {
+	case dedupeByURL:
+		iter := db.Aevt().Datoms2(mu.Keyword("note", "url"), nil, nil)
+		for datom := iter.Next(); datom != nil; datom = iter.Next() {
+			imp.existing[fmt.Sprint(datom.V().Val())] = datom.E()
+		}
+	case dedupeByContent:
+		iter := db.Aevt().Datoms2(mu.Keyword("note", "content"), nil, nil)
+		for datom := iter.Next(); datom != nil; datom = iter.Next() {
+			post := Post{db.Entity(datom.E())}
+			imp.existing[contentHash(post.Title(), post.Content())] = datom.E()
+		}
+	}
+
+	return imp, nil
+}
+
+func contentHash(title, content string) string {
+	hash := sha1.Sum([]byte("# " + title + "\n" + content))
+	return hex.EncodeToString(hash[:])
+}
+
+func (imp *noteImporter) dedupeKey(note importNote) string {
+	switch imp.key {
+	case dedupeByURL:
+		if note.URL == nil {
+			return ""
+		}
+		return note.URL.String()
+	case dedupeByContent:
+		return contentHash(note.Title, note.Content)
+	default:
+		return ""
+	}
+}
+
+// skipRecord counts a record and returns whether it was already imported
+// before resuming.
+func (imp *noteImporter) skipRecord() bool {
+	imp.records += 1
+	return imp.records <= imp.skipRecords
+}
+
+// Invalid records that the data at source could not be imported.
+func (imp *noteImporter) Invalid(source string, err error) {
+	if imp.skipRecord() {
+		return
+	}
+	imp.invalidf(source, err)
+}
+
+func (imp *noteImporter) invalidf(source string, err error) {
+	imp.invalid = append(imp.invalid, fmt.Sprintf("%s: %s", source, err))
+}
+
+// Ignore records that the data at source was skipped on purpose.
+func (imp *noteImporter) Ignore(source string, reason string) {
+	imp.ignored = append(imp.ignored, fmt.Sprintf("%s: %s", source, reason))
+}
+
+func (imp *noteImporter) reportf(format string, args ...interface{}) {
+	imp.report = append(imp.report, fmt.Sprintf(format, args...))
+}
+
+// Add adds note to the import, or applies the conflict policy if it
+// exists already.  The current batch is committed once it is full.
+func (imp *noteImporter) Add(note importNote) error {
+	if imp.skipRecord() {
+		return nil
+	}
+
+	imp.add(note)
+
+	imp.batchNotes += 1
+	if imp.batchSize > 0 && imp.batchNotes >= imp.batchSize && !imp.dryRun {
+		return imp.flush()
+	}
+	return nil
+}
+
+func (imp *noteImporter) add(note importNote) {
+	if note.Title == "" && note.Content == "" {
+		imp.invalidf(note.Source, fmt.Errorf("empty note"))
+		return
+	}
+	if note.Date.IsZero() {
+		imp.invalidf(note.Source, fmt.Errorf("missing date"))
+		return
+	}
+	if err := checkNoteId(note.Id); err != nil {
+		imp.invalidf(note.Source, err)
+		return
+	}
+
+	noteId := findNote(imp.db, note.Id)
+	if id, ok := imp.existing["id:"+note.Id]; noteId == -1 && ok {
+		noteId = id
+	}
+	key := imp.dedupeKey(note)
+	if noteId == -1 && key != "" {
+		if id, ok := imp.existing[key]; ok {
+			noteId = id
+		}
+	}
+
+	if noteId == -1 {
+		imp.create(note, key)
+		return
+	}
+
+	_, isPending := imp.pending[noteId]
+	switch {
+	case isPending:
+		// duplicate within the imported data, only tags are merged
+		if imp.policy == conflictMergeTags {
+			imp.mergeTags(noteId, nil, note)
+		}
+		imp.reportf("skip      %q (duplicate, %s)", note.Title, note.Source)
+		imp.skipped += 1
+	case imp.policy == conflictOverwrite:
+		imp.overwrite(noteId, note)
+	case imp.policy == conflictMergeTags:
+		imp.mergeTags(noteId, Post{imp.db.Entity(noteId)}.Tags(), note)
+	default:
+		imp.reportf("skip      %q (exists)", note.Title)
+		imp.skipped += 1
+	}
+}
+
+func (imp *noteImporter) create(note importNote, key string) {
+	if note.Id == "" {
+		note.Id = generateId()
+	}
+
+	imp.tempid -= 1
+	noteId := mu.Tempid(mu.DbPartUser, imp.tempid)
+	imp.txData = append(imp.txData, imp.noteTxMap(noteId, note, nil))
+	imp.pending[noteId] = note.Id
+	imp.existing["id:"+note.Id] = noteId
+	if key != "" {
+		imp.existing[key] = noteId
+	}
+	imp.reportf("create    %q", note.Title)
+	imp.created += 1
+}
+
+func (imp *noteImporter) overwrite(noteId int, note importNote) {
+	post := Post{imp.db.Entity(noteId)}
+	if samePost(post, note) {
+		imp.reportf("skip      %q (unchanged)", note.Title)
+		imp.skipped += 1
+		return
+	}
+
+	imp.txData = append(imp.txData, retractTags(imp.db, noteId, note.Tags)...)
+	imp.txData = append(imp.txData, retractAbsent(noteId, post, note)...)
+	// keep the existing id, so that permalinks stay valid
+	imp.txData = append(imp.txData, imp.noteTxMap(noteId, note, &post))
+	imp.pending[noteId] = ""
+	imp.reportf("overwrite %q (%s)", note.Title, post.Id())
+	imp.updated += 1
+}
+
+func (imp *noteImporter) mergeTags(noteId int, existing []Tag, note importNote) {
+	has := map[string]bool{}
+	for _, tag := range existing {
+		has[tag.Name()] = true
+	}
+
+	newTags := make([]string, 0)
+	for _, tag := range note.Tags {
+		if !has[tag] {
+			newTags = append(newTags, tag)
+		}
+	}
+	_, isPending := imp.pending[noteId]
+	if len(newTags) == 0 {
+		if !isPending {
+			imp.reportf("skip      %q (exists)", note.Title)
+			imp.skipped += 1
+		}
+		return
+	}
+
+	imp.txData = append(imp.txData, tx.TxMap{
+		Id: mu.Id(noteId),
+		Attributes: map[database.Keyword][]tx.Value{
+			mu.Keyword("note", "tags"): imp.tags.Values(newTags),
+		},
+	})
+	if !isPending {
+		imp.pending[noteId] = ""
+		imp.reportf("merge     %q (tags %s)", note.Title, strings.Join(newTags, " "))
+		imp.updated += 1
+	}
+}
+
+// noteTxMap returns the transaction data for note.  existing is the note
+// that is overwritten, or nil for new notes.
+func (imp *noteImporter) noteTxMap(noteId int, note importNote, existing *Post) tx.TxMap {
+	txMap := tx.TxMap{
+		Id: mu.Id(noteId),
+		Attributes: map[database.Keyword][]tx.Value{
+			mu.Keyword("note", "title"):   []tx.Value{tx.NewValue(note.Title)},
+			mu.Keyword("note", "content"): []tx.Value{tx.NewValue(note.Content)},
+			mu.Keyword("note", "date"):    []tx.Value{tx.NewValue(note.Date)},
+		},
+	}
+	if existing == nil {
+		txMap.Attributes[mu.Keyword("note", "id")] = []tx.Value{tx.NewValue(note.Id)}
+	}
+	if !note.Modified.IsZero() {
+		txMap.Attributes[mu.Keyword("note", "modified")] = []tx.Value{tx.NewValue(note.Modified)}
+	}
+	if note.URL != nil {
+		txMap.Attributes[mu.Keyword("note", "url")] = []tx.Value{tx.NewValue(note.URL)}
+	}
+	if len(note.Tags) > 0 {
+		txMap.Attributes[mu.Keyword("note", "tags")] = imp.tags.Values(note.Tags)
+	}
+	// flags are only asserted if they are set or changed, so that notes
+	// without them work with databases created before they existed
+	if note.ToRead != (existing != nil && existing.ToRead()) {
+		txMap.Attributes[mu.Keyword("note", "toread")] = []tx.Value{tx.NewValue(note.ToRead)}
+	}
+	if note.Private != (existing != nil && existing.Private()) {
+		txMap.Attributes[mu.Keyword("note", "private")] = []tx.Value{tx.NewValue(note.Private)}
+	}
+	return txMap
+}
+
+// flush transacts the current batch and records the progress.
+func (imp *noteImporter) flush() error {
+	txData := append(imp.txData, imp.tags.TxData()...)
+	if len(txData) > 0 {
+		txRes, err := mu.Transact(imp.conn, txData)
+		if err != nil {
+			return err
+		}
+		imp.datoms += len(txRes.Datoms)
+	}
+	imp.newTags += len(imp.tags.created)
+
+	if imp.batchSize > 0 {
+		imp.batches += 1
+		fmt.Printf("batch %d: committed %d records (%d created, %d updated, %d skipped)\n",
+			imp.batches, imp.records, imp.created, imp.updated, imp.skipped)
+	}
+	// the notes and tags of this batch exist now, so they are looked up
+	// in the new database in the next batches
+	imp.db = imp.conn.Db()
+	for key, noteId := range imp.existing {
+		if id := imp.pending[noteId]; id != "" {
+			imp.existing[key] = findNote(imp.db, id)
+		}
+	}
+	imp.pending = map[int]string{}
+	imp.tags = newTagIds(imp.db)
+	imp.txData = make([]tx.TxDatum, 0)
+	imp.tempid = 0
+	imp.batchNotes = 0
+
+	// the batch is committed, so failing to record that only means that
+	// resuming would repeat it
+	if imp.progressPath != "" {
+		err := ioutil.WriteFile(imp.progressPath, []byte(strconv.Itoa(imp.records)+"\n"), 0644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Warning: recording the progress:", err)
+		}
+	}
+	return nil
+}
+
+// Flush transacts the notes added so far.  It does nothing for dry runs.
+func (imp *noteImporter) Flush() error {
+	if imp.dryRun || imp.batchNotes == 0 {
+		return nil
+	}
+	return imp.flush()
+}
+
+// Commit transacts the remaining notes and prints a summary.
+//
+// For dry runs it prints what would have been imported instead.
+func (imp *noteImporter) Commit() error {
+	if imp.dryRun {
+		for _, line := range imp.report {
+			fmt.Println(line)
+		}
+		for _, tag := range imp.tags.created {
+			fmt.Printf("new tag   %q\n", tag)
+		}
+		imp.newTags = len(imp.tags.created)
+	} else {
+		err := imp.flush()
+		if err != nil {
+			return err
+		}
+
+		if imp.progressPath != "" {
+			err = os.Remove(imp.progressPath)
+			if err != nil && !os.IsNotExist(err) {
+				fmt.Fprintln(os.Stderr, "Warning: removing the progress:", err)
+			}
+		}
+	}
+
+	for _, ignored := range imp.ignored {
+		fmt.Fprintln(os.Stderr, "skipped:", ignored)
+	}
+	for _, invalid := range imp.invalid {
+		fmt.Fprintln(os.Stderr, "invalid:", invalid)
+	}
+
+	// the number of datoms is only known once they are transacted
+	verb, datoms := "created", fmt.Sprintf(", %d datoms", imp.datoms)
+	if imp.dryRun {
+		verb, datoms = "would have created", ""
+	}
+	fmt.Printf("%s %d, updated %d, skipped %d notes (%d new tags, %d invalid, %d skipped files%s)\n",
+		verb, imp.created, imp.updated, imp.skipped, imp.newTags, len(imp.invalid), len(imp.ignored), datoms)
+	return nil
+}
+
+// decodeJSONArray calls fn for each element of the JSON array in r, so
+// that large arrays do not have to be read into memory at once.
+func decodeJSONArray(r io.Reader, fn func(decoder *json.Decoder) error) error {
+	decoder := json.NewDecoder(r)
+	t, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := t.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("expected a JSON array, but got %v", t)
+	}
+
+	for decoder.More() {
+		err = fn(decoder)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = decoder.Token()
+	return err
+}
+
+// samePost returns whether importing note would not change post.
+func samePost(post Post, note importNote) bool {
+	if post.Title() != note.Title || post.Content() != note.Content || !post.Date().Equal(note.Date) {
+		return false
+	}
+	if !post.Modified().Equal(note.Modified) {
+		return false
+	}
+	if post.ToRead() != note.ToRead || post.Private() != note.Private {
+		return false
+	}
+
+	u := post.URL()
+	if (u == nil) != (note.URL == nil) || (u != nil && u.String() != note.URL.String()) {
+		return false
+	}
+
+	tags := post.Tags()
+	if len(tags) != len(note.Tags) {
+		return false
+	}
+	has := map[string]bool{}
+	for _, tag := range tags {
+		has[tag.Name()] = true
+	}
+	for _, tag := range note.Tags {
+		if !has[tag] {
+			return false
+		}
+	}
+	return true
+}
+
+// tagIds assigns entity ids to tag names for a transaction.
+//
+// Tags that already exist in the database are reused, new tags get a
+// tempid and are asserted by TxData.
+type tagIds struct {
+	db      *database.Database
+	ids     map[string]int
+	created []string
+	tempid  int
+}
+
+func newTagIds(db *database.Database) *tagIds {
+	return &tagIds{
+		db:     db,
+		ids:    map[string]int{},
+		tempid: -1000000,
+	}
+}
+
+func (t *tagIds) Id(tag string) int {
+	if id, ok := t.ids[tag]; ok {
+		return id
+	}
+
+	id := t.db.Entid(mu.LookupRef(mu.Keyword("tag", "name"), tag))
+	if id == -1 {
+		t.tempid -= 1
+		id = mu.Tempid(mu.DbPartUser, t.tempid)
+		t.created = append(t.created, tag)
+	}
+	t.ids[tag] = id
+	return id
+}
+
+// Values returns the ids of tags as values for the note/tags attribute.
+func (t *tagIds) Values(tags []string) []tx.Value {
+	values := make([]tx.Value, len(tags))
+	for i, tag := range tags {
+		values[i] = tx.NewValue(mu.Id(t.Id(tag)))
+	}
+	return values
+}
+
+// TxData returns the datoms asserting the tags that did not exist yet.
+func (t *tagIds) TxData() []tx.TxDatum {
+	txData := make([]tx.TxDatum, 0, len(t.created))
+	for _, tag := range t.created {
+		txData = append(txData, tx.Datum{
+			Op: tx.Assert,
+			E:  mu.Id(t.ids[tag]),
+			A:  mu.Keyword("tag", "name"),
+			V:  tx.NewValue(tag),
+		})
+	}
+	return txData
+}
+
+// findNote returns the entity id of the note with the given note/id, or
+// -1 if there is no such note.
+func findNote(db *database.Database, id string) int {
+	if id == "" {
+		return -1
+	}
+	return db.Entid(mu.LookupRef(mu.Keyword("note", "id"), id))
+}
+
+// retractAbsent retracts the optional attributes of post that note does
+// not have, so that an overwritten note does not keep a stale url or
+// modification date.
+func retractAbsent(noteId int, post Post, note importNote) []tx.TxDatum {
+	txData := make([]tx.TxDatum, 0)
+	if u := post.URL(); u != nil && note.URL == nil {
+		txData = append(txData, tx.Datum{
+			Op: tx.Retract,
+			E:  mu.Id(noteId),
+			A:  mu.Keyword("note", "url"),
+			V:  tx.NewValue(u),
+		})
+	}
+	if modified := post.Modified(); !modified.IsZero() && note.Modified.IsZero() {
+		txData = append(txData, tx.Datum{
+			Op: tx.Retract,
+			E:  mu.Id(noteId),
+			A:  mu.Keyword("note", "modified"),
+			V:  tx.NewValue(modified),
+		})
+	}
+	return txData
+}
+
+// retractTags returns datoms retracting the tags of the note with
+// entity id noteId that are not in tags.
+func retractTags(db *database.Database, noteId int, tags []string) []tx.TxDatum {
+	keep := map[string]bool{}
+	for _, tag := range tags {
+		keep[tag] = true
+	}
+
+	txData := make([]tx.TxDatum, 0)
+	for _, tag := range (Post{db.Entity(noteId)}).Tags() {
+		if keep[tag.Name()] {
+			continue
+		}
+
+		tagId := db.Entid(mu.LookupRef(mu.Keyword("tag", "name"), tag.Name()))
+		txData = append(txData, tx.Datum{
+			Op: tx.Retract,
+			E:  mu.Id(noteId),
+			A:  mu.Keyword("note", "tags"),
+			V:  tx.NewValue(mu.Id(tagId)),
+		})
+	}
+	return txData
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+func init() {
+	RegisterImporter("bibtex", Importer{
+		Description: "BibTeX bibliography (.bib)",
+		Read:        readBibTeX,
+		Key:         dedupeById,
+		Policy:      conflictSkip,
+	})
+}
+
+// bibEntry is an entry in a BibTeX file.
+type bibEntry struct {
+	Type string
+	Key  string
+	// Fields maps the lower case field names to their values, with
+	// macros expanded and concatenations joined.
+	Fields map[string]string
+	// Raw is the entry as it is written in the file.
+	Raw  string
+	Line int
+}
+
+var (
+	bibKeyRe    = regexp.MustCompile(`^[A-Za-z0-9_.:+-]+$`)
+	bibAuthorRe = regexp.MustCompile(`\s+and\s+`)
+	// bibAccentRe matches LaTeX accents like \"o, {\"o} and \"{o}.
+	bibAccentRe  = regexp.MustCompile(`\{?\\([` + "`" + `'^"~=.])\{?([A-Za-z])\}?\}?`)
+	bibCommandRe = regexp.MustCompile(`\\(ss|ae|AE|oe|OE|aa|AA|o|O|l|L)\b\s*`)
+)
+
+// bibMonths are the predefined month macros.
+var bibMonths = map[string]string{
+	"jan": "January", "feb": "February", "mar": "March", "apr": "April",
+	"may": "May", "jun": "June", "jul": "July", "aug": "August",
+	"sep": "September", "oct": "October", "nov": "November", "dec": "December",
+}
+
+// bibAccents maps the accent commands to pairs of letters and the
+// letters with that accent, so that accented letters are written as single
+// characters and match text typed elsewhere.
+var bibAccents = map[string]string{
+	"`":  "aàeèiìoòuùAÀEÈIÌOÒUÙ",
+	"'":  "aácćeéiínńoósśuúyýzźAÁCĆEÉIÍNŃOÓSŚUÚYÝZŹ",
+	"^":  "aâeêiîoôuûAÂEÊIÎOÔUÛ",
+	"~":  "aãnñoõAÃNÑOÕ",
+	"=":  "aāeēiīoōuūAĀEĒIĪOŌUŪ",
+	".":  "zżZŻ",
+	"\"": "aäeëiïoöuüyÿAÄEËIÏOÖUÜYŸ",
+}
+
+// bibCombining maps the accent commands to combining characters, for the
+// letters without a precomposed form in bibAccents.
+var bibCombining = map[string]rune{
+	"`": '\u0300', "'": '\u0301', "^": '\u0302', "~": '\u0303',
+	"=": '\u0304', ".": '\u0307', "\"": '\u0308',
+}
+
+var bibCommands = map[string]string{
+	"ss": "ß", "ae": "æ", "AE": "Æ", "oe": "œ", "OE": "Œ",
+	"aa": "å", "AA": "Å", "o": "ø", "O": "Ø", "l": "ł", "L": "Ł",
+}
+
+// readBibTeX reads the entries in a BibTeX file.
+//
+// The citation key becomes the id, or a safe name derived from it if it
+// contains characters that ids cannot (like the "/" in DBLP keys).  The
+// original entry is kept in the content, so that export-bibtex writes it
+// with the same key.  The authors and keywords become tags, the year (and
+// month) the date and the abstract the content, followed by the original
+// entry.  The url is the url field, or a link to the DOI or arXiv id.
+func readBibTeX(path string, opts importOptions, notes noteSink) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	return parseBibTeX(string(data), func(entry bibEntry, err error) error {
+		source := fmt.Sprintf("%s:%d", path, entry.Line)
+		if err != nil {
+			notes.Invalid(source, err)
+			return nil
+		}
+
+		note, err := bibNote(entry)
+		if err != nil {
+			notes.Invalid(source, err)
+			return nil
+		}
+		note.Source = source
+		if note.Date.IsZero() {
+			note.Date = fi.ModTime()
+		}
+		return notes.Add(note)
+	})
+}
+
+func bibNote(entry bibEntry) (importNote, error) {
+	note := importNote{
+		Id:    entry.Key,
+		Title: bibText(entry.Fields["title"]),
+	}
+	if !bibKeyRe.MatchString(entry.Key) || strings.Contains(entry.Key, "..") {
+		note.Id = safeName(entry.Key)
+	}
+	if note.Title == "" {
+		note.Title = entry.Key
+	}
+
+	abstract := bibText(entry.Fields["abstract"])
+	if abstract != "" {
+		abstract += "\n\n"
+	}
+	note.Content = abstract + "```bibtex\n" + entry.Raw + "\n```\n"
+
+	for _, author := range bibAuthors(entry.Fields["author"]) {
+		note.Tags = append(note.Tags, tagName(author))
+	}
+	for _, keyword := range strings.FieldsFunc(entry.Fields["keywords"], func(r rune) bool { return r == ',' || r == ';' }) {
+		if tag := tagName(bibText(keyword)); tag != "" {
+			note.Tags = append(note.Tags, tag)
+		}
+	}
+
+	if year := strings.TrimSpace(entry.Fields["year"]); year != "" {
+		y, err := strconv.Atoi(year)
+		if err != nil {
+			return note, fmt.Errorf("invalid year %q", year)
+		}
+		note.Date = time.Date(y, bibMonth(entry.Fields["month"]), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	rawURL := strings.TrimSpace(entry.Fields["url"])
+	switch {
+	case rawURL != "":
+	case entry.Fields["doi"] != "":
+		doi := strings.TrimPrefix(strings.TrimSpace(entry.Fields["doi"]), "https://doi.org/")
+		rawURL = "https://doi.org/" + doi
+	case strings.EqualFold(entry.Fields["archiveprefix"], "arxiv") && entry.Fields["eprint"] != "":
+		rawURL = "https://arxiv.org/abs/" + strings.TrimSpace(entry.Fields["eprint"])
+	}
+	if rawURL != "" {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return note, err
+		}
+		note.URL = u
+	}
+
+	return note, nil
+}
+
+// bibAuthors returns the names in an author field as "First Last".
+func bibAuthors(field string) []string {
+	var authors []string
+	for _, name := range bibAuthorRe.Split(strings.TrimSpace(field), -1) {
+		name = bibText(name)
+		if name == "" || name == "others" {
+			continue
+		}
+		if parts := strings.SplitN(name, ",", 2); len(parts) == 2 {
+			name = strings.TrimSpace(parts[1]) + " " + strings.TrimSpace(parts[0])
+		}
+		authors = append(authors, name)
+	}
+	return authors
+}
+
+func bibMonth(field string) time.Month {
+	field = strings.ToLower(strings.TrimSpace(field))
+	if m, err := strconv.Atoi(field); err == nil && m >= 1 && m <= 12 {
+		return time.Month(m)
+	}
+	for m := time.January; m <= time.December; m++ {
+		if len(field) >= 3 && strings.HasPrefix(strings.ToLower(m.String()), field[:3]) {
+			return m
+		}
+	}
+	return time.January
+}
+
+// bibText converts a BibTeX value to plain text, resolving the common
+// LaTeX accents and escapes and removing braces.
+func bibText(s string) string {
+	s = bibAccentRe.ReplaceAllStringFunc(s, func(accent string) string {
+		m := bibAccentRe.FindStringSubmatch(accent)
+		return bibAccent(m[1], m[2])
+	})
+	s = bibCommandRe.ReplaceAllStringFunc(s, func(command string) string {
+		return bibCommands[strings.TrimSpace(command)[1:]]
+	})
+	s = strings.NewReplacer(
+		`\&`, "&", `\%`, "%", `\$`, "$", `\#`, "#", `\_`, "_",
+		"~", " ", "---", "—", "--", "–", "{", "", "}", "",
+	).Replace(s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// bibAccent returns letter with the accent of command.
+func bibAccent(command, letter string) string {
+	letters := []rune(bibAccents[command])
+	for i := 0; i+1 < len(letters); i += 2 {
+		if string(letters[i]) == letter {
+			return string(letters[i+1])
+		}
+	}
+	return letter + string(bibCombining[command])
+}
+
+// parseBibTeX calls fn for each entry in data.  Entries that could not be
+// parsed are passed with an error, errors returned by fn stop the parsing.
+// @string macros are expanded, @comment and @preamble are skipped.
+func parseBibTeX(data string, fn func(bibEntry, error) error) error {
+	macros := map[string]string{}
+	for k, v := range bibMonths {
+		macros[k] = v
+	}
+
+	// line is the line of lineAt, counted as the scan advances
+	pos, line, lineAt := 0, 1, 0
+	for {
+		at := strings.IndexByte(data[pos:], '@')
+		if at == -1 {
+			return nil
+		}
+		start := pos + at
+		line += strings.Count(data[lineAt:start], "\n")
+		lineAt = start
+
+		p := &bibParser{data: data, pos: start + 1}
+		typ := strings.ToLower(p.identifier())
+		p.skipSpace()
+		if typ == "" || p.pos >= len(data) || (data[p.pos] != '{' && data[p.pos] != '(') {
+			// text between entries is a comment
+			pos = start + 1
+			continue
+		}
+		end := p.matching()
+		if end == -1 {
+			return fn(bibEntry{Line: line}, fmt.Errorf("unterminated entry"))
+		}
+		pos = end + 1
+
+		entry := bibEntry{Type: typ, Line: line, Raw: data[start : end+1], Fields: map[string]string{}}
+		p.end = end
+		p.pos += 1
+		p.macros = macros
+
+		var err error
+		switch typ {
+		case "comment", "preamble":
+			continue
+		case "string":
+			err = p.fields(macros)
+		default:
+			p.skipSpace()
+			entry.Key = strings.TrimSpace(p.until(','))
+			if entry.Key == "" {
+				err = fmt.Errorf("missing citation key")
+				break
+			}
+			err = p.fields(entry.Fields)
+		}
+		if typ == "string" && err == nil {
+			continue
+		}
+
+		err = fn(entry, err)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+type bibParser struct {
+	data     string
+	pos, end int
+	macros   map[string]string
+}
+
+func (p *bibParser) skipSpace() {
+	for p.pos < len(p.data) && unicode.IsSpace(rune(p.data[p.pos])) {
+		p.pos += 1
+	}
+}
+
+func (p *bibParser) identifier() string {
+	start := p.pos
+	for p.pos < len(p.data) && strings.IndexByte(" \t\r\n{}(),=#\"", p.data[p.pos]) == -1 {
+		p.pos += 1
+	}
+	return p.data[start:p.pos]
+}
+
+// matching returns the position of the delimiter that closes the one at
+// the current position, or -1.
+func (p *bibParser) matching() int {
+	open := p.data[p.pos]
+	if open == '(' {
+		depth := 0
+		for i := p.pos; i < len(p.data); i++ {
+			switch p.data[i] {
+			case '(':
+				depth += 1
+			case ')':
+				depth -= 1
+				if depth == 0 {
+					return i
+				}
+			}
+		}
+		return -1
+	}
+
+	depth := 0
+	for i := p.pos; i < len(p.data); i++ {
+		switch p.data[i] {
+		case '{':
+			depth += 1
+		case '}':
+			depth -= 1
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// until returns the text up to c (or the end of the entry) and skips c.
+func (p *bibParser) until(c byte) string {
+	i := strings.IndexByte(p.data[p.pos:p.end], c)
+	if i == -1 {
+		s := p.data[p.pos:p.end]
+		p.pos = p.end
+		return s
+	}
+	s := p.data[p.pos : p.pos+i]
+	p.pos += i + 1
+	return s
+}
+
+// fields parses "name = value" pairs up to the end of the entry into
+// fields.
+func (p *bibParser) fields(fields map[string]string) error {
+	for {
+		p.skipSpace()
+		for p.pos < p.end && p.data[p.pos] == ',' {
+			p.pos += 1
+			p.skipSpace()
+		}
+		if p.pos >= p.end {
+			return nil
+		}
+
+		name := strings.ToLower(p.identifier())
+		p.skipSpace()
+		if name == "" || p.pos >= p.end || p.data[p.pos] != '=' {
+			return fmt.Errorf("expected field at %q", bibContext(p.data[p.pos:p.end]))
+		}
+		p.pos += 1
+
+		value, err := p.value()
+		if err != nil {
+			return fmt.Errorf("field %s: %s", name, err)
+		}
+		fields[name] = value
+	}
+}
+
+// value parses a field value: braced or quoted strings, numbers and macros
+// concatenated with "#".
+func (p *bibParser) value() (string, error) {
+	var parts []string
+	for {
+		p.skipSpace()
+		if p.pos >= p.end {
+			return "", fmt.Errorf("missing value")
+		}
+
+		switch c := p.data[p.pos]; c {
+		case '{':
+			end := p.matching()
+			if end == -1 || end > p.end {
+				return "", fmt.Errorf("unterminated value")
+			}
+			parts = append(parts, p.data[p.pos+1:end])
+			p.pos = end + 1
+		case '"':
+			depth := 0
+			i := p.pos + 1
+			for ; i < p.end; i++ {
+				if p.data[i] == '{' {
+					depth += 1
+				} else if p.data[i] == '}' {
+					depth -= 1
+				} else if p.data[i] == '"' && depth == 0 {
+					break
+				}
+			}
+			if i >= p.end {
+				return "", fmt.Errorf("unterminated value")
+			}
+			parts = append(parts, p.data[p.pos+1:i])
+			p.pos = i + 1
+		default:
+			word := p.identifier()
+			if word == "" {
+				return "", fmt.Errorf("unexpected %q", c)
+			}
+			if expanded, ok := p.macros[strings.ToLower(word)]; ok {
+				word = expanded
+			}
+			parts = append(parts, word)
+		}
+
+		p.skipSpace()
+		if p.pos < p.end && p.data[p.pos] == '#' {
+			p.pos += 1
+			continue
+		}
+		return strings.Join(parts, ""), nil
+	}
+}
+
+func bibContext(s string) string {
+	if len(s) > 20 {
+		s = s[:20] + "..."
+	}
+	return s
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBibTeX(t *testing.T) {
+	data := `% a comment with an @ sign
+@string{nips = "Advances in Neural Information Processing Systems"}
+
+@inproceedings{DBLP:conf/nips/VaswaniSPUJGKP17,
+  title     = {Attention is All you Need},
+  booktitle = nips # " 30",
+  year      = 2017,
+  month     = dec,
+}
+
+@comment{not an entry}
+@misc(plain, title = "A {B}raced title")
+@article{broken, title = {unterminated}
+`
+
+	type entry struct {
+		typ, key string
+		line     int
+		fields   map[string]string
+		err      bool
+	}
+	expected := []entry{
+		{"inproceedings", "DBLP:conf/nips/VaswaniSPUJGKP17", 4, map[string]string{
+			"title":     "Attention is All you Need",
+			"booktitle": "Advances in Neural Information Processing Systems 30",
+			"year":      "2017",
+			"month":     "December",
+		}, false},
+		{"misc", "plain", 12, map[string]string{"title": "A {B}raced title"}, false},
+		{"", "", 13, nil, true},
+	}
+
+	var entries []entry
+	err := parseBibTeX(data, func(e bibEntry, err error) error {
+		if err != nil {
+			entries = append(entries, entry{line: e.Line, err: true})
+			return nil
+		}
+		entries = append(entries, entry{e.Type, e.Key, e.Line, e.Fields, false})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("got %+v, expected %+v", entries, expected)
+	}
+}
+
+func TestBibText(t *testing.T) {
+	tests := []struct {
+		bib, text string
+	}{
+		{`G{\"o}del`, "Gödel"},
+		{`Erd\"{o}s and \'Emile`, "Erdös and Émile"},
+		{`\^{a} \~n \.{z} \=o`, "â ñ ż ō"},
+		{`Stra{\ss}e`, "Straße"},
+		{`{The} {B}est \& more -- 50\%`, "The Best & more – 50%"},
+		{"  spaced\n  out ", "spaced out"},
+	}
+
+	for _, test := range tests {
+		if text := bibText(test.bib); text != test.text {
+			t.Errorf("%q: got %q, expected %q", test.bib, text, test.text)
+		}
+	}
+}
+
+func TestBibNoteId(t *testing.T) {
+	tests := []struct {
+		key, id string
+	}{
+		{"vaswani2017", "vaswani2017"},
+		{"Knuth:1984", "Knuth:1984"},
+		{"DBLP:conf/nips/Vaswani17", safeName("DBLP:conf/nips/Vaswani17")},
+		{"../../evil", safeName("../../evil")},
+	}
+
+	for _, test := range tests {
+		raw := "@misc{" + test.key + ", title = {T}}"
+		note, err := bibNote(bibEntry{Type: "misc", Key: test.key, Fields: map[string]string{"title": "T"}, Raw: raw})
+		if err != nil {
+			t.Errorf("%q: %s", test.key, err)
+			continue
+		}
+		if note.Id != test.id || checkNoteId(note.Id) != nil {
+			t.Errorf("%q: got id %q, expected %q", test.key, note.Id, test.id)
+		}
+		if !strings.Contains(note.Content, raw) {
+			t.Errorf("%q: the original entry is not kept", test.key)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterImporter("bookmarks-html", Importer{
+		Description: "Netscape bookmark file (bookmarks.html)",
+		Read:        readBookmarksHTML,
+		Key:         dedupeByURL,
+		Policy:      conflictSkip,
+	})
+}
+
+// readBookmarksHTML reads bookmarks from a Netscape bookmark file, the
+// bookmarks.html format exported by browsers and most bookmarking
+// services.
+//
+// The folders a bookmark is in and its TAGS become tags, ADD_DATE is used
+// as the date.
+func readBookmarksHTML(path string, opts importOptions, notes noteSink) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return parseBookmarksHTML(f, func(note importNote, err error) error {
+		if err != nil {
+			notes.Invalid(note.Source, err)
+			return nil
+		}
+		return notes.Add(note)
+	})
+}
+
+// parseBookmarksHTML calls fn for each bookmark in r.  Bookmarks that
+// could not be parsed are passed with an error, errors returned by fn stop
+// the parsing.
+//
+// The format is not quite HTML, for example <DT> and <p> are never
+// closed, so it is read with a lenient XML decoder and only the structure
+// of the <DL>, <H3>, <A> and <DD> elements is used.
+func parseBookmarksHTML(r io.Reader, fn func(importNote, error) error) error {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.AutoClose = append(xml.HTMLAutoClose, "p", "dt")
+	decoder.Entity = xml.HTMLEntity
+
+	folders := make([]string, 0)
+	folder := ""
+	n := 0
+	var last *importNote
+	var lastErr error
+	flush := func() error {
+		if last == nil {
+			return nil
+		}
+
+		note, err := *last, lastErr
+		last, lastErr = nil, nil
+		return fn(note, err)
+	}
+
+	var token xml.Token
+	next := func() (xml.Token, error) {
+		if token != nil {
+			t := token
+			token = nil
+			return t, nil
+		}
+		return decoder.Token()
+	}
+
+	for {
+		t, err := next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			switch strings.ToLower(t.Name.Local) {
+			case "h3":
+				err = flush()
+				if err != nil {
+					return err
+				}
+				folder, err = readText(decoder, t.Name.Local)
+				if err != nil {
+					return err
+				}
+			case "dl":
+				folders = append(folders, folder)
+				folder = ""
+			case "a":
+				err = flush()
+				if err != nil {
+					return err
+				}
+				n += 1
+				note, parseErr := parseBookmark(t, folders)
+				note.Source = fmt.Sprintf("bookmark %d", n)
+				note.Title, err = readText(decoder, t.Name.Local)
+				if err != nil {
+					return err
+				}
+				last, lastErr = &note, parseErr
+			case "dd":
+				// the description continues until the next element
+				description := ""
+				for {
+					t, err := decoder.Token()
+					if err == io.EOF {
+						break
+					} else if err != nil {
+						return err
+					}
+					if data, ok := t.(xml.CharData); ok {
+						description += string(data)
+						continue
+					}
+					token = xml.CopyToken(t)
+					break
+				}
+				if last != nil {
+					last.Content = strings.TrimSpace(description)
+				}
+			}
+		case xml.EndElement:
+			if strings.ToLower(t.Name.Local) == "dl" && len(folders) > 0 {
+				err = flush()
+				if err != nil {
+					return err
+				}
+				folders = folders[:len(folders)-1]
+			}
+		}
+	}
+	return flush()
+}
+
+func parseBookmark(a xml.StartElement, folders []string) (importNote, error) {
+	note := importNote{}
+	// the outermost folder is the list of all bookmarks, bookmarks
+	// outside of any list have no folders at all
+	for i, folder := range folders {
+		if i > 0 && folder != "" {
+			note.Tags = append(note.Tags, tagName(folder))
+		}
+	}
+
+	var err error
+	for _, attr := range a.Attr {
+		switch strings.ToLower(attr.Name.Local) {
+		case "href":
+			note.URL, err = url.Parse(attr.Value)
+			if err != nil {
+				return note, err
+			}
+		case "add_date":
+			note.Date, err = parseUnixTime(attr.Value)
+			if err != nil {
+				return note, err
+			}
+		case "tags":
+			for _, tag := range strings.Split(attr.Value, ",") {
+				if tag = tagName(tag); tag != "" {
+					note.Tags = append(note.Tags, tag)
+				}
+			}
+		case "toread":
+			note.ToRead = attr.Value == "1"
+		case "private":
+			note.Private = attr.Value == "1"
+		}
+	}
+
+	if note.URL == nil {
+		return note, fmt.Errorf("missing HREF")
+	}
+	if note.Date.IsZero() {
+		note.Date = time.Now().Round(time.Second)
+	}
+	return note, nil
+}
+
+// readText returns the text up to the end of the element called name.
+func readText(decoder *xml.Decoder, name string) (string, error) {
+	text := ""
+	for {
+		t, err := decoder.Token()
+		if err != nil {
+			return "", err
+		}
+
+		switch t := t.(type) {
+		case xml.CharData:
+			text += string(t)
+		case xml.EndElement:
+			if strings.EqualFold(t.Name.Local, name) {
+				return strings.TrimSpace(text), nil
+			}
+		}
+	}
+}
+
+// parseUnixTime parses a timestamp in seconds since the epoch.  Some
+// exporters use milli- or microseconds instead, which are detected by
+// their size.
+func parseUnixTime(s string) (time.Time, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	switch {
+	case n > 1e14:
+		return time.Unix(0, n*int64(time.Microsecond)), nil
+	case n > 1e11:
+		return time.Unix(0, n*int64(time.Millisecond)), nil
+	default:
+		return time.Unix(n, 0), nil
+	}
+}
+
+// tagName turns s into a tag name, which may not contain spaces.
+func tagName(s string) string {
+	return strings.Join(strings.Fields(s), "-")
+}
@@ -1,71 +1,132 @@
 package main
 
 import (
-	"fmt"
-	"github.com/heyLu/mu"
-	"github.com/heyLu/mu/connection"
-	"github.com/heyLu/mu/database"
-	tx "github.com/heyLu/mu/transactor"
 	"io/ioutil"
+	"net/url"
 	"os"
-	"path"
+	"path/filepath"
 	"strings"
 )
 
-func ImportFromDirectory(directory string, conn connection.Connection) error {
-	f, err := os.Open(directory)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
+// noteExtensions are the file extensions readDirectory imports, other
+// files are skipped.
+var noteExtensions = map[string]bool{
+	"":          true,
+	".md":       true,
+	".markdown": true,
+	".txt":      true,
+	".text":     true,
+}
 
-	fis, err := f.Readdir(-1)
-	if err != nil {
-		return err
-	}
+func init() {
+	RegisterImporter("directory", Importer{
+		Description: "a directory of Markdown files",
+		Read:        readDirectory,
+		Key:         dedupeByContent,
+		Policy:      conflictSkip,
+	})
+}
 
-	n := -1
-	txData := make([]tx.TxDatum, 0)
-	for _, fi := range fis {
+// readDirectory reads the files in directory and its subdirectories as
+// notes.
+//
+// The title is taken from a leading "# title" line, the date from the
+// modification time.  A front matter block can set the title, date, url,
+// tags and id explicitly.  With opts.directoryTags, the names of the
+// subdirectories a file is in are added as tags.
+//
+// Notes are matched by their id or their title and content, so importing
+// a directory twice does not duplicate its notes.
+func readDirectory(directory string, opts importOptions, notes noteSink) error {
+	return filepath.Walk(directory, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(fi.Name(), ".") && p != directory {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		if fi.IsDir() {
-			continue
+			return nil
+		}
+		if !noteExtensions[strings.ToLower(filepath.Ext(p))] {
+			notes.Ignore(p, "unknown file type")
+			return nil
 		}
 
-		data, err := ioutil.ReadFile(path.Join(directory, fi.Name()))
+		data, err := ioutil.ReadFile(p)
 		if err != nil {
 			return err
 		}
 
-		title := fi.Name()
-		content := string(data)
+		note, err := parseNoteFile(fi.Name(), string(data))
+		if err != nil {
+			notes.Invalid(p, err)
+			return nil
+		}
+		note.Source = p
+		if note.Date.IsZero() {
+			note.Date = fi.ModTime()
+		}
 
+		if opts.directoryTags {
+			rel, err := filepath.Rel(directory, filepath.Dir(p))
+			if err != nil {
+				return err
+			}
+			if rel != "." {
+				note.Tags = append(note.Tags, strings.Split(filepath.ToSlash(rel), "/")...)
+			}
+		}
+
+		return notes.Add(note)
+	})
+}
+
+// parseNoteFile parses a note written in the "# title\ncontent"
+// convention, optionally preceded by front matter.
+func parseNoteFile(name, data string) (importNote, error) {
+	fm, content, err := parseFrontMatter(data)
+	if err != nil {
+		return importNote{}, err
+	}
+
+	note := importNote{
+		Id:      fm.String("id"),
+		Title:   fm.String("title"),
+		Content: content,
+		Tags:    fm.List("tags"),
+		ToRead:  fm.String("toread") == "true",
+		Private: fm.String("private") == "true",
+	}
+
+	if note.Title == "" {
+		note.Title = name
 		if newLine := strings.IndexByte(content, '\n'); newLine != -1 {
 			firstLine := content[0:newLine]
 			if strings.HasPrefix(firstLine, "# ") && len(firstLine) > 2 {
-				title = firstLine[2:]
-				content = content[newLine+1:]
+				note.Title = firstLine[2:]
+				note.Content = content[newLine+1:]
 			}
 		}
+	}
 
-		txDatum := tx.TxMap{
-			Id: mu.Id(mu.Tempid(mu.DbPartUser, n)),
-			Attributes: map[database.Keyword][]tx.Value{
-				mu.Keyword("note", "id"):      []tx.Value{tx.NewValue(generateId())},
-				mu.Keyword("note", "title"):   []tx.Value{tx.NewValue(title)},
-				mu.Keyword("note", "content"): []tx.Value{tx.NewValue(content)},
-				mu.Keyword("note", "date"):    []tx.Value{tx.NewValue(fi.ModTime())},
-			},
+	if rawDate := fm.String("date"); rawDate != "" {
+		note.Date, err = parseDate(rawDate)
+		if err != nil {
+			return importNote{}, err
 		}
-
-		n -= 1
-		txData = append(txData, txDatum)
 	}
 
-	txRes, err := mu.Transact(conn, txData)
-	if err != nil {
-		return err
+	if rawURL := fm.String("url"); rawURL != "" {
+		note.URL, err = url.Parse(rawURL)
+		if err != nil {
+			return importNote{}, err
+		}
 	}
-	fmt.Println("added", len(txRes.Datoms), "datoms")
 
-	return nil
+	return note, nil
 }
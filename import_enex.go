@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"time"
+)
+
+func init() {
+	RegisterImporter("enex", Importer{
+		Description: "Evernote export (.enex)",
+		Read:        readENEX,
+		Key:         dedupeByContent,
+		Policy:      conflictSkip,
+	})
+}
+
+// enexNote is a note in an Evernote export.  The content is ENML, a
+// subset of XHTML.
+type enexNote struct {
+	Title     string   `xml:"title"`
+	Content   string   `xml:"content"`
+	Created   string   `xml:"created"`
+	Tags      []string `xml:"tag"`
+	SourceURL string   `xml:"note-attributes>source-url"`
+}
+
+// enexTimeFormat is the format of the timestamps in Evernote exports.
+const enexTimeFormat = "20060102T150405Z"
+
+// readENEX reads the notes in an Evernote export, converting their
+// content to Markdown.
+func readENEX(path string, opts importOptions, notes noteSink) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := xml.NewDecoder(f)
+	n := 0
+	for {
+		t, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		start, ok := t.(xml.StartElement)
+		if !ok || start.Name.Local != "note" {
+			continue
+		}
+
+		var enex enexNote
+		err = decoder.DecodeElement(&enex, &start)
+		if err != nil {
+			return err
+		}
+
+		n += 1
+		source := fmt.Sprintf("note %d", n)
+		note, err := parseENEXNote(enex)
+		if err != nil {
+			notes.Invalid(source, err)
+			continue
+		}
+		note.Source = source
+
+		err = notes.Add(note)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func parseENEXNote(enex enexNote) (importNote, error) {
+	content, err := htmlToMarkdown(enex.Content)
+	if err != nil {
+		return importNote{}, err
+	}
+
+	note := importNote{
+		Title:   enex.Title,
+		Content: content,
+	}
+
+	note.Date, err = time.Parse(enexTimeFormat, enex.Created)
+	if err != nil {
+		return importNote{}, err
+	}
+
+	for _, tag := range enex.Tags {
+		if tag = tagName(tag); tag != "" {
+			note.Tags = append(note.Tags, tag)
+		}
+	}
+
+	if enex.SourceURL != "" {
+		note.URL, err = url.Parse(enex.SourceURL)
+		if err != nil {
+			return importNote{}, err
+		}
+	}
+
+	return note, nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterImporter("git", Importer{
+		Description: "the history of Markdown notes in a git repository",
+		Read:        readGit,
+		Key:         dedupeById,
+		Policy:      conflictOverwrite,
+	})
+}
+
+// gitCommitMarker separates the commits in the output of git log.
+const gitCommitMarker = "\x1e"
+
+// gitChange is a file added, modified, renamed or deleted in a commit.
+type gitChange struct {
+	Status  byte
+	OldPath string
+	Path    string
+}
+
+// readGit imports the history of the notes in a git repository, oldest
+// commit first.
+//
+// Each commit is transacted separately, so that the database keeps every
+// revision of the notes.  The notes get a stable id derived from their
+// path (which follows renames), unless their front matter sets one.  The
+// date is the author time of the first commit of a note, note/modified
+// the author time of the commit of each revision.  Only the first parents
+// of merges are followed, and deleted files are reported, but kept.
+func readGit(repo string, opts importOptions, notes noteSink) error {
+	log, err := exec.Command("git", "-C", repo, "-c", "core.quotePath=false",
+		"log", "--reverse", "--first-parent", "-m", "--relative", "-M", "--name-status",
+		"--format="+gitCommitMarker+"%H %at").Output()
+	if err != nil {
+		return fmt.Errorf("git log: %s", gitError(err))
+	}
+
+	blobs, err := newGitBlobs(repo)
+	if err != nil {
+		return err
+	}
+	defer blobs.Close()
+
+	ids := map[string]string{}
+	created := map[string]time.Time{}
+	for _, commit := range strings.Split(string(log), gitCommitMarker)[1:] {
+		lines := strings.Split(strings.TrimSpace(commit), "\n")
+		header := strings.Fields(lines[0])
+		if len(header) != 2 {
+			return fmt.Errorf("unexpected git log output %q", lines[0])
+		}
+		hash := header[0]
+		seconds, err := strconv.ParseInt(header[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		commitTime := time.Unix(seconds, 0)
+
+		for _, line := range lines[1:] {
+			change, ok := parseGitChange(line)
+			if !ok || !isGitNote(change.Path) {
+				continue
+			}
+			source := fmt.Sprintf("%s:%s", hash[:10], change.Path)
+
+			if change.Status == 'D' {
+				notes.Ignore(source, "deleted, the note is kept")
+				continue
+			}
+
+			data, err := blobs.Get(hash, change.Path)
+			if err != nil {
+				notes.Invalid(source, err)
+				continue
+			}
+			note, err := parseNoteFile(path.Base(change.Path), string(data))
+			if err != nil {
+				notes.Invalid(source, err)
+				continue
+			}
+			note.Source = source
+
+			id, ok := ids[change.Path]
+			if !ok && change.Status == 'R' {
+				id, ok = ids[change.OldPath]
+			}
+			if !ok {
+				id = gitNoteId(change.Path)
+			}
+			if note.Id == "" {
+				note.Id = id
+			}
+			ids[change.Path] = note.Id
+
+			if _, ok := created[note.Id]; !ok {
+				created[note.Id] = commitTime
+			}
+			if note.Date.IsZero() {
+				note.Date = created[note.Id]
+			}
+			note.Modified = commitTime
+
+			if opts.directoryTags && path.Dir(change.Path) != "." {
+				note.Tags = append(note.Tags, strings.Split(path.Dir(change.Path), "/")...)
+			}
+
+			err = notes.Add(note)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = notes.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseGitChange parses a line of git log --name-status, like
+// "M\tpath" or "R087\told\tnew".
+func parseGitChange(line string) (gitChange, bool) {
+	parts := strings.Split(line, "\t")
+	if len(parts) < 2 || parts[0] == "" {
+		return gitChange{}, false
+	}
+
+	change := gitChange{Status: parts[0][0], Path: gitUnquote(parts[len(parts)-1])}
+	switch change.Status {
+	case 'R', 'C':
+		if len(parts) != 3 {
+			return gitChange{}, false
+		}
+		change.OldPath = gitUnquote(parts[1])
+	case 'A', 'M', 'D', 'T':
+	default:
+		return gitChange{}, false
+	}
+	return change, true
+}
+
+// gitUnquote unquotes paths with special characters, which git writes
+// as C strings.
+func gitUnquote(p string) string {
+	if strings.HasPrefix(p, "\"") {
+		if unquoted, err := strconv.Unquote(p); err == nil {
+			return unquoted
+		}
+	}
+	return p
+}
+
+func isGitNote(p string) bool {
+	for _, part := range strings.Split(p, "/") {
+		if strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+	return noteExtensions[strings.ToLower(path.Ext(p))]
+}
+
+func gitNoteId(p string) string {
+	hash := sha1.Sum([]byte(p))
+	return "git-" + hex.EncodeToString(hash[:5])
+}
+
+func gitError(err error) string {
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return strings.TrimSpace(string(exitErr.Stderr))
+	}
+	return err.Error()
+}
+
+// gitBlobs reads files at commits with a single git cat-file process.
+type gitBlobs struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+func newGitBlobs(repo string) (*gitBlobs, error) {
+	cmd := exec.Command("git", "-C", repo, "cat-file", "--batch")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	return &gitBlobs{cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout)}, nil
+}
+
+// Get returns the content of the file at p in commit.  p is relative to
+// the directory the history was read from.
+func (b *gitBlobs) Get(commit, p string) ([]byte, error) {
+	_, err := fmt.Fprintf(b.stdin, "%s:./%s\n", commit, p)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := b.stdout.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(header)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("git cat-file: %s", strings.TrimSpace(header))
+	}
+	size, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, size+1)
+	_, err = io.ReadFull(b.stdout, data)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(data, []byte("\n")), nil
+}
+
+func (b *gitBlobs) Close() error {
+	b.stdin.Close()
+	return b.cmd.Wait()
+}
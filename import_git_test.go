@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestParseGitChange(t *testing.T) {
+	tests := []struct {
+		line   string
+		change gitChange
+		ok     bool
+	}{
+		{"M\tnotes/a.md", gitChange{Status: 'M', Path: "notes/a.md"}, true},
+		{"A\ta.md", gitChange{Status: 'A', Path: "a.md"}, true},
+		{"D\ta.md", gitChange{Status: 'D', Path: "a.md"}, true},
+		{"R087\told.md\tnew.md", gitChange{Status: 'R', OldPath: "old.md", Path: "new.md"}, true},
+		{"C100\ta.md\tb.md", gitChange{Status: 'C', OldPath: "a.md", Path: "b.md"}, true},
+		{"M\t\"caf\\303\\251.md\"", gitChange{Status: 'M', Path: "café.md"}, true},
+		{"R087\tonly-one.md", gitChange{}, false},
+		{"X\ta.md", gitChange{}, false},
+		{"commit 1234", gitChange{}, false},
+		{"", gitChange{}, false},
+	}
+
+	for _, test := range tests {
+		change, ok := parseGitChange(test.line)
+		if ok != test.ok || change != test.change {
+			t.Errorf("%q: got %+v, %v", test.line, change, ok)
+		}
+	}
+}
@@ -3,57 +3,78 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"github.com/heyLu/mu"
-	"github.com/heyLu/mu/connection"
-	"github.com/heyLu/mu/database"
-	tx "github.com/heyLu/mu/transactor"
+	"net/url"
 	"os"
 	"time"
 )
 
+// jsonPost is the format read by readJSON and written by
+// ExportToJSON.  Older exports use "created" instead of "date".
 type jsonPost struct {
-	Id      string    `json:"id"`
-	Title   string    `json:"title"`
-	Content string    `json:"content"`
-	Date    time.Time `json:"created"`
+	Id       string    `json:"id"`
+	Title    string    `json:"title"`
+	Content  string    `json:"content"`
+	Date     time.Time `json:"date"`
+	Created  time.Time `json:"created"`
+	Modified time.Time `json:"modified"`
+	URL      string    `json:"url"`
+	Tags     []string  `json:"tags"`
+	ToRead   bool      `json:"toread"`
+	Private  bool      `json:"private"`
 }
 
-func ImportFromJSON(path string, conn connection.Connection) error {
+func init() {
+	// notes are matched by id and overwritten by default, so that their
+	// permalinks (/notes/{id}) stay valid
+	RegisterImporter("json", Importer{
+		Description: "notes exported by export-json",
+		Read:        readJSON,
+		Key:         dedupeById,
+		Policy:      conflictOverwrite,
+	})
+}
+
+// readJSON reads notes exported by ExportToJSON.
+func readJSON(path string, opts importOptions, notes noteSink) error {
 	f, err := os.Open(path)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	decoder := json.NewDecoder(f)
-	var posts []jsonPost
-	err = decoder.Decode(&posts)
-	if err != nil {
-		return err
-	}
-
-	n := -1
-	txData := make([]tx.TxDatum, 0)
-	for _, post := range posts {
-		txDatum := tx.TxMap{
-			Id: mu.Id(mu.Tempid(mu.DbPartUser, n)),
-			Attributes: map[database.Keyword][]tx.Value{
-				mu.Keyword("note", "id"):      []tx.Value{tx.NewValue(generateId())},
-				mu.Keyword("note", "title"):   []tx.Value{tx.NewValue(post.Title)},
-				mu.Keyword("note", "content"): []tx.Value{tx.NewValue(post.Content)},
-				mu.Keyword("note", "date"):    []tx.Value{tx.NewValue(post.Date)},
-			},
+	n := 0
+	return decodeJSONArray(f, func(decoder *json.Decoder) error {
+		var post jsonPost
+		err := decoder.Decode(&post)
+		if err != nil {
+			return err
 		}
+		n += 1
 
-		n -= 1
-		txData = append(txData, txDatum)
-	}
+		note := importNote{
+			Id:       post.Id,
+			Title:    post.Title,
+			Content:  post.Content,
+			Date:     post.Date,
+			Modified: post.Modified,
+			Tags:     post.Tags,
+			ToRead:   post.ToRead,
+			Private:  post.Private,
+			Source:   fmt.Sprintf("note %d", n),
+		}
+		if note.Date.IsZero() {
+			note.Date = post.Created
+		}
 
-	txRes, err := mu.Transact(conn, txData)
-	if err != nil {
-		return err
-	}
-	fmt.Println("added", len(txRes.Datoms), "datoms")
+		if post.URL != "" {
+			u, err := url.Parse(post.URL)
+			if err != nil {
+				notes.Invalid(note.Source, err)
+				return nil
+			}
+			note.URL = u
+		}
 
-	return nil
+		return notes.Add(note)
+	})
 }
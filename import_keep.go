@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterImporter("keep", Importer{
+		Description: "Google Keep notes from Google Takeout",
+		Read:        readKeep,
+		Key:         dedupeByContent,
+		Policy:      conflictSkip,
+	})
+}
+
+// keepNote is a note in Google Keep's Takeout export, which contains one
+// JSON file per note.
+type keepNote struct {
+	Title       string `json:"title"`
+	TextContent string `json:"textContent"`
+	ListContent []struct {
+		Text      string `json:"text"`
+		IsChecked bool   `json:"isChecked"`
+	} `json:"listContent"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	Annotations []struct {
+		URL string `json:"url"`
+	} `json:"annotations"`
+	IsTrashed               bool  `json:"isTrashed"`
+	CreatedTimestampUsec    int64 `json:"createdTimestampUsec"`
+	UserEditedTimestampUsec int64 `json:"userEditedTimestampUsec"`
+}
+
+// readKeep reads the notes in a Google Keep Takeout directory.
+//
+// Labels become tags and checklists are converted to Markdown task lists.
+// Trashed notes are skipped unless opts.keepTrashed is set.
+func readKeep(directory string, opts importOptions, notes noteSink) error {
+	return filepath.Walk(directory, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		switch filepath.Ext(p) {
+		case ".json":
+		case ".html":
+			// every note is exported as HTML as well
+			return nil
+		default:
+			notes.Ignore(p, "not a Keep note")
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		var keep keepNote
+		err = json.NewDecoder(f).Decode(&keep)
+		if err != nil {
+			notes.Invalid(p, err)
+			return nil
+		}
+
+		if keep.IsTrashed && !opts.keepTrashed {
+			notes.Ignore(p, "trashed")
+			return nil
+		}
+
+		note, err := parseKeepNote(keep, strings.TrimSuffix(fi.Name(), ".json"))
+		if err != nil {
+			notes.Invalid(p, err)
+			return nil
+		}
+		note.Source = p
+		return notes.Add(note)
+	})
+}
+
+func parseKeepNote(keep keepNote, name string) (importNote, error) {
+	note := importNote{
+		Title: keep.Title,
+	}
+
+	content := keep.TextContent
+	if len(keep.ListContent) > 0 {
+		items := make([]string, len(keep.ListContent))
+		for i, item := range keep.ListContent {
+			check := " "
+			if item.IsChecked {
+				check = "x"
+			}
+			items[i] = "- [" + check + "] " + item.Text
+		}
+		if content != "" {
+			content += "\n\n"
+		}
+		content += strings.Join(items, "\n")
+	}
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	note.Content = content
+
+	// untitled notes are named after their date in the export
+	if note.Title == "" {
+		note.Title = name
+	}
+
+	usec := keep.CreatedTimestampUsec
+	if usec == 0 {
+		usec = keep.UserEditedTimestampUsec
+	}
+	if usec != 0 {
+		note.Date = time.Unix(0, usec*int64(time.Microsecond))
+	}
+
+	for _, label := range keep.Labels {
+		if tag := tagName(label.Name); tag != "" {
+			note.Tags = append(note.Tags, tag)
+		}
+	}
+
+	for _, annotation := range keep.Annotations {
+		if annotation.URL == "" {
+			continue
+		}
+
+		u, err := url.Parse(annotation.URL)
+		if err != nil {
+			return note, err
+		}
+		note.URL = u
+		break
+	}
+
+	return note, nil
+}
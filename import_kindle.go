@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterImporter("kindle", Importer{
+		Description: "Kindle highlights (My Clippings.txt)",
+		Read:        readKindle,
+		Key:         dedupeByContent,
+		Policy:      conflictSkip,
+	})
+}
+
+// kindleClipping is a highlight, note or bookmark in "My Clippings.txt".
+type kindleClipping struct {
+	Book     string
+	Kind     string // "highlight", "note" or "bookmark"
+	Location string
+	Date     time.Time
+	Text     string
+	Line     int
+}
+
+const kindleSeparator = "=========="
+
+var (
+	kindleKindRe     = regexp.MustCompile(`(?i)your (highlight|note|bookmark)`)
+	kindleLocationRe = regexp.MustCompile(`(?i)(?:location|loc\.) ([\d-]+)`)
+	kindlePageRe     = regexp.MustCompile(`(?i)page ([\w-]+)`)
+)
+
+// kindleDateFormats are the formats of the "Added on" date of clippings,
+// which depend on the region and the model of the Kindle.
+var kindleDateFormats = []string{
+	"Monday, January 2, 2006 3:04:05 PM",
+	"Monday, 2 January 2006 15:04:05",
+	"Monday, January 2, 2006 15:04:05",
+	"Monday, 2 January 2006 3:04:05 PM",
+	"Monday, January 2, 2006, 3:04 PM",
+	"Monday, 2 January 2006, 15:04",
+}
+
+// readKindle reads the highlights in a Kindle "My Clippings.txt".
+//
+// Every highlight becomes a note, tagged with the title of the book.
+// With opts.kindleBooks, there is one note per book instead, containing
+// all of its highlights.  Kindle writes a clipping again when it is
+// changed, those repeated clippings are only imported once.
+func readKindle(path string, opts importOptions, notes noteSink) error {
+	clippings, err := parseKindleClippings(path, notes)
+	if err != nil {
+		return err
+	}
+	clippings = dedupeKindleClippings(clippings)
+
+	if !opts.kindleBooks {
+		for _, clipping := range clippings {
+			if clipping.Kind == "bookmark" {
+				continue
+			}
+
+			err = notes.Add(kindleNote(clipping))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	books := make([]string, 0)
+	byBook := map[string][]kindleClipping{}
+	for _, clipping := range clippings {
+		if clipping.Kind == "bookmark" {
+			continue
+		}
+		if _, ok := byBook[clipping.Book]; !ok {
+			books = append(books, clipping.Book)
+		}
+		byBook[clipping.Book] = append(byBook[clipping.Book], clipping)
+	}
+
+	for _, book := range books {
+		err = notes.Add(kindleBookNote(book, byBook[book]))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseKindleClippings(path string, notes noteSink) ([]kindleClipping, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	clippings := make([]kindleClipping, 0)
+	lines := make([]string, 0)
+	start := 1
+	scanner := bufio.NewScanner(f)
+	lineNumber := 0
+	for scanner.Scan() {
+		line := strings.TrimRight(strings.TrimPrefix(scanner.Text(), "\ufeff"), "\r")
+		lineNumber += 1
+
+		if line != kindleSeparator {
+			lines = append(lines, line)
+			continue
+		}
+
+		clipping, err := parseKindleClipping(lines)
+		source := fmt.Sprintf("%s:%d", path, start)
+		if err != nil {
+			notes.Invalid(source, err)
+		} else {
+			clipping.Line = start
+			if clipping.Date.IsZero() {
+				clipping.Date = fi.ModTime()
+			}
+			clippings = append(clippings, clipping)
+		}
+		lines = lines[:0]
+		start = lineNumber + 1
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return clippings, nil
+}
+
+func parseKindleClipping(lines []string) (kindleClipping, error) {
+	if len(lines) < 2 {
+		return kindleClipping{}, fmt.Errorf("incomplete clipping")
+	}
+
+	clipping := kindleClipping{
+		Book: strings.TrimSpace(lines[0]),
+		Text: strings.TrimSpace(strings.Join(lines[2:], "\n")),
+	}
+
+	for _, part := range strings.Split(lines[1], "|") {
+		part = strings.TrimSpace(part)
+		if m := kindleKindRe.FindStringSubmatch(part); m != nil {
+			clipping.Kind = strings.ToLower(m[1])
+		}
+		if m := kindleLocationRe.FindStringSubmatch(part); m != nil {
+			clipping.Location = m[1]
+		} else if m := kindlePageRe.FindStringSubmatch(part); m != nil && clipping.Location == "" {
+			clipping.Location = "page " + m[1]
+		}
+		if strings.HasPrefix(part, "Added on ") {
+			// clippings with a date in an unknown format are kept,
+			// dated like the file
+			clipping.Date = parseKindleDate(part[len("Added on "):])
+		}
+	}
+
+	if clipping.Kind == "" {
+		return clipping, fmt.Errorf("unknown clipping type %q", lines[1])
+	}
+	return clipping, nil
+}
+
+// parseKindleDate returns the date in one of kindleDateFormats, or the
+// zero time.
+func parseKindleDate(s string) time.Time {
+	for _, format := range kindleDateFormats {
+		date, err := time.ParseInLocation(format, s, time.Local)
+		if err == nil {
+			return date
+		}
+	}
+	return time.Time{}
+}
+
+// dedupeKindleClippings removes repeated clippings.  A highlight that
+// was extended later is replaced by the extended version.
+func dedupeKindleClippings(clippings []kindleClipping) []kindleClipping {
+	deduped := make([]kindleClipping, 0, len(clippings))
+	for _, clipping := range clippings {
+		duplicate := false
+		for i, existing := range deduped {
+			if existing.Book != clipping.Book || existing.Kind != clipping.Kind {
+				continue
+			}
+
+			if existing.Text == clipping.Text && existing.Location == clipping.Location {
+				duplicate = true
+				break
+			}
+
+			if clipping.Kind == "highlight" && kindleLocationsOverlap(existing.Location, clipping.Location) {
+				if strings.Contains(clipping.Text, existing.Text) {
+					deduped[i] = clipping
+					duplicate = true
+					break
+				}
+				if strings.Contains(existing.Text, clipping.Text) {
+					duplicate = true
+					break
+				}
+			}
+		}
+
+		if !duplicate {
+			deduped = append(deduped, clipping)
+		}
+	}
+	return deduped
+}
+
+// kindleLocationsOverlap returns whether the locations a and b overlap.
+// Locations that could not be parsed, and pages and locations, never
+// overlap.
+func kindleLocationsOverlap(a, b string) bool {
+	aPage, bPage := strings.HasPrefix(a, "page "), strings.HasPrefix(b, "page ")
+	if aPage != bPage {
+		return false
+	}
+	aStart, aEnd, aOk := kindleLocationRange(strings.TrimPrefix(a, "page "))
+	bStart, bEnd, bOk := kindleLocationRange(strings.TrimPrefix(b, "page "))
+	return aOk && bOk && aStart <= bEnd && bStart <= aEnd
+}
+
+// kindleLocationRange parses a location or page like "1234" or
+// "1234-56".  Roman page numbers are not supported.
+func kindleLocationRange(location string) (start, end int, ok bool) {
+	parts := strings.SplitN(location, "-", 2)
+	start, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	end = start
+	if len(parts) == 2 {
+		end, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return 0, 0, false
+		}
+		// "1234-56" means 1234-1256
+		if end < start {
+			digits := len(parts[1])
+			prefix := start
+			for i := 0; i < digits; i++ {
+				prefix /= 10
+			}
+			for i := 0; i < digits; i++ {
+				prefix *= 10
+			}
+			end += prefix
+		}
+	}
+	return start, end, true
+}
+
+// kindleBookTag returns the tag for book, its title without the author
+// that Kindle adds in parentheses.
+func kindleBookTag(book string) string {
+	if strings.HasSuffix(book, ")") {
+		if i := strings.LastIndex(book, " ("); i > 0 {
+			book = book[:i]
+		}
+	}
+	return tagName(book)
+}
+
+func kindleNote(clipping kindleClipping) importNote {
+	title := clipping.Book
+	if clipping.Location != "" {
+		title += ", location " + clipping.Location
+	}
+	if clipping.Kind == "note" {
+		title = "Note on " + title
+	}
+
+	return importNote{
+		Title:   title,
+		Content: clipping.Text + "\n",
+		Date:    clipping.Date,
+		Tags:    []string{kindleBookTag(clipping.Book)},
+		Source:  fmt.Sprintf("line %d", clipping.Line),
+	}
+}
+
+// kindleBookNote returns a note with all clippings of book.  Its id is
+// derived from the title, so that importing more highlights later updates
+// the note when overwriting.
+func kindleBookNote(book string, clippings []kindleClipping) importNote {
+	hash := sha1.Sum([]byte(book))
+	note := importNote{
+		Id:     "kindle-" + hex.EncodeToString(hash[:5]),
+		Title:  book,
+		Tags:   []string{kindleBookTag(book)},
+		Source: fmt.Sprintf("line %d", clippings[0].Line),
+	}
+
+	content := make([]string, 0, len(clippings))
+	for _, clipping := range clippings {
+		if note.Date.IsZero() || clipping.Date.Before(note.Date) {
+			note.Date = clipping.Date
+		}
+
+		text := "> " + strings.Replace(clipping.Text, "\n", "\n> ", -1)
+		if clipping.Kind == "note" {
+			text = clipping.Text
+		}
+		if clipping.Location != "" {
+			text += "\n\n(location " + clipping.Location + ")"
+		}
+		content = append(content, text)
+	}
+	note.Content = strings.Join(content, "\n\n") + "\n"
+
+	return note
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterImporter("notion", Importer{
+		Description: "Notion Markdown & CSV export (.zip)",
+		Read:        readNotion,
+		Key:         dedupeById,
+		Policy:      conflictSkip,
+	})
+}
+
+var (
+	// notionNameRe matches the id Notion appends to file names.
+	notionNameRe = regexp.MustCompile(`^(.*?)\s*([0-9a-f]{32})$`)
+	// notionLinkRe matches links to other pages of the export.
+	notionLinkRe = regexp.MustCompile(`\]\(([^)\s]+\.md)\)`)
+)
+
+// notionTagColumns, notionDateColumns and notionURLColumns are the
+// database properties that are mapped to tags, the date and the url.  The
+// date and url are taken from the first of their columns that is set, so
+// that imports of the same export always agree.
+var (
+	notionTagColumns  = []string{"tags", "tag", "labels", "keywords", "category", "categories"}
+	notionDateColumns = []string{"created", "created time", "created at", "date"}
+	notionURLColumns  = []string{"url", "link", "source"}
+)
+
+var notionDateFormats = []string{
+	"January 2, 2006 3:04 PM",
+	"January 2, 2006",
+	"2006/01/02 15:04",
+	"2006/01/02",
+}
+
+// notionRow is a row of a Notion database, whose page is stored in a
+// directory named like the CSV file.
+type notionRow map[string]string
+
+// readNotion reads the pages in a Notion export zip.
+//
+// The ids Notion appends to file names are used as the note ids and
+// removed from the titles, links between pages are rewritten to
+// /notes/{id}.  Pages in databases get their tags, date and url from the
+// properties of their row.
+func readNotion(zipPath string, opts importOptions, notes noteSink) error {
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// databases maps the directories of database pages to their rows,
+	// by title
+	databases := map[string]map[string]notionRow{}
+	for _, f := range r.File {
+		if path.Ext(f.Name) != ".csv" || strings.HasSuffix(f.Name, "_all.csv") {
+			continue
+		}
+
+		rows, err := readNotionCSV(f)
+		if err != nil {
+			notes.Invalid(f.Name, err)
+			continue
+		}
+		databases[strings.TrimSuffix(f.Name, ".csv")] = rows
+	}
+
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || path.Ext(f.Name) == ".csv" {
+			continue
+		}
+		if path.Ext(f.Name) != ".md" {
+			notes.Ignore(f.Name, "not a page")
+			continue
+		}
+
+		note, err := readNotionPage(f, databases)
+		if err != nil {
+			notes.Invalid(f.Name, err)
+			continue
+		}
+		note.Source = f.Name
+
+		err = notes.Add(note)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readNotionCSV(f *zip.File) (map[string]notionRow, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	records, err := csv.NewReader(rc).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	header := records[0]
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+
+	rows := map[string]notionRow{}
+	for _, record := range records[1:] {
+		row := notionRow{}
+		for i, value := range record {
+			if i < len(header) {
+				row[strings.ToLower(strings.TrimSpace(header[i]))] = value
+			}
+		}
+		// the first column is the title
+		rows[record[0]] = row
+	}
+	return rows, nil
+}
+
+func readNotionPage(f *zip.File, databases map[string]map[string]notionRow) (importNote, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return importNote{}, err
+	}
+	defer rc.Close()
+
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return importNote{}, err
+	}
+
+	title, id := notionName(strings.TrimSuffix(path.Base(f.Name), ".md"))
+	note := importNote{
+		Id:    id,
+		Title: title,
+		Date:  f.Modified,
+	}
+	if note.Date.IsZero() {
+		note.Date = f.ModTime()
+	}
+
+	content := strings.Replace(string(data), "\r\n", "\n", -1)
+	if strings.HasPrefix(content, "# ") {
+		i := strings.IndexByte(content, '\n')
+		if i == -1 {
+			i = len(content)
+		}
+		note.Title = strings.TrimSpace(content[2:i])
+		content = strings.TrimLeft(content[i:], "\n")
+	}
+
+	if row, ok := databases[path.Dir(f.Name)][note.Title]; ok {
+		content, err = applyNotionRow(&note, row, content)
+		if err != nil {
+			return note, err
+		}
+	}
+
+	note.Content = rewriteNotionLinks(content, path.Dir(f.Name))
+	return note, nil
+}
+
+// applyNotionRow sets the tags, date and url of note from the properties
+// in row, and removes those properties from the start of content.
+func applyNotionRow(note *importNote, row notionRow, content string) (string, error) {
+	for _, column := range notionTagColumns {
+		for _, tag := range strings.Split(row[column], ",") {
+			if tag = tagName(tag); tag != "" {
+				note.Tags = append(note.Tags, tag)
+			}
+		}
+	}
+
+	for _, column := range notionDateColumns {
+		value := strings.TrimSpace(row[column])
+		if value == "" {
+			continue
+		}
+
+		// ranges are written as "start → end"
+		value = strings.TrimSpace(strings.SplitN(value, "→", 2)[0])
+		date, err := parseNotionDate(value)
+		if err != nil {
+			return content, err
+		}
+		note.Date = date
+		break
+	}
+
+	for _, column := range notionURLColumns {
+		value := strings.TrimSpace(row[column])
+		if value == "" {
+			continue
+		}
+
+		u, err := url.Parse(value)
+		if err != nil {
+			return content, err
+		}
+		note.URL = u
+		break
+	}
+
+	// the properties are written as "Name: value" lines before the
+	// content of the page
+	lines := strings.Split(content, "\n")
+	i := 0
+	for ; i < len(lines); i++ {
+		parts := strings.SplitN(lines[i], ":", 2)
+		if len(parts) != 2 {
+			break
+		}
+		if _, ok := row[strings.ToLower(strings.TrimSpace(parts[0]))]; !ok {
+			break
+		}
+	}
+	return strings.TrimLeft(strings.Join(lines[i:], "\n"), "\n"), nil
+}
+
+func parseNotionDate(s string) (time.Time, error) {
+	for _, format := range notionDateFormats {
+		t, err := time.ParseInLocation(format, s, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return parseDate(s)
+}
+
+// notionName splits a file name into the title and the Notion id.
+func notionName(name string) (title, id string) {
+	m := notionNameRe.FindStringSubmatch(name)
+	if m == nil {
+		return name, ""
+	}
+	return m[1], m[2]
+}
+
+// rewriteNotionLinks rewrites links to other pages in the export to
+// their notes.  dir is the directory of the page the links are in.
+func rewriteNotionLinks(content, dir string) string {
+	return notionLinkRe.ReplaceAllStringFunc(content, func(link string) string {
+		target := notionLinkRe.FindStringSubmatch(link)[1]
+		if strings.Contains(target, "://") {
+			return link
+		}
+
+		unescaped, err := url.PathUnescape(target)
+		if err != nil {
+			return link
+		}
+
+		_, id := notionName(strings.TrimSuffix(path.Base(path.Join(dir, unescaped)), ".md"))
+		if id == "" {
+			return link
+		}
+		return fmt.Sprintf("](/notes/%s)", id)
+	})
+}
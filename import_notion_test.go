@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestNotionName(t *testing.T) {
+	tests := []struct {
+		name, title, id string
+	}{
+		{"Reading list 0123456789abcdef0123456789abcdef", "Reading list", "0123456789abcdef0123456789abcdef"},
+		{"Untitled0123456789abcdef0123456789abcdef", "Untitled", "0123456789abcdef0123456789abcdef"},
+		{"Reading list", "Reading list", ""},
+		{"Short id 0123456789abcdef", "Short id 0123456789abcdef", ""},
+	}
+
+	for _, test := range tests {
+		title, id := notionName(test.name)
+		if title != test.title || id != test.id {
+			t.Errorf("%q: got %q, %q", test.name, title, id)
+		}
+	}
+}
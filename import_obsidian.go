@@ -0,0 +1,294 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+func init() {
+	RegisterImporter("obsidian", Importer{
+		Description: "an Obsidian vault",
+		Read:        readObsidian,
+		Key:         dedupeById,
+		Policy:      conflictSkip,
+	})
+}
+
+var (
+	// obsidianWikiLinkRe matches [[target#heading|label]] links and
+	// ![[target]] embeds.
+	obsidianWikiLinkRe = regexp.MustCompile(`(!?)\[\[([^\]|#^]*)([#^][^\]|]*)?(?:\|([^\]]*))?\]\]`)
+	// obsidianLinkRe matches Markdown links to files in the vault.
+	obsidianLinkRe = regexp.MustCompile(`(!?)\[([^\]]*)\]\(([^)\s]+)\)`)
+	// obsidianTagRe matches inline #tags, which must contain something
+	// other than digits.
+	obsidianTagRe = regexp.MustCompile(`(?:^|\s)#([\p{L}\p{N}_/-]*[\p{L}_/-][\p{L}\p{N}_/-]*)`)
+)
+
+// obsidianVault is the index of a vault used to resolve links.
+type obsidianVault struct {
+	// notes maps the lower case paths (without ".md"), names and aliases
+	// of notes to their ids.
+	notes map[string]string
+	// attachments maps the lower case paths and names of other files to
+	// their paths, and records whether they were linked to.
+	attachments map[string]string
+	linked      map[string]bool
+}
+
+// readObsidian reads the notes in an Obsidian vault.
+//
+// Every note gets a stable id derived from its path in the vault (or the
+// id in its front matter), so that [[wiki links]] and links to other
+// notes can be rewritten to /notes/{id}.  Tags are taken from the front
+// matter and from inline #tags.  Attachments are not imported, links to
+// them and broken links are reported.
+func readObsidian(directory string, opts importOptions, notes noteSink) error {
+	vault := &obsidianVault{
+		notes:       map[string]string{},
+		attachments: map[string]string{},
+		linked:      map[string]bool{},
+	}
+
+	var files []string
+	err := walkObsidian(directory, func(rel string, data []byte) error {
+		if path.Ext(rel) != ".md" {
+			vault.attachments[strings.ToLower(rel)] = rel
+			vault.attachments[strings.ToLower(path.Base(rel))] = rel
+			return nil
+		}
+		files = append(files, rel)
+
+		fm, _, err := parseFrontMatter(string(data))
+		if err != nil {
+			// reported when the note is read
+			fm = frontMatter{}
+		}
+		id := fm.String("id")
+		if id == "" {
+			id = obsidianId(rel)
+		}
+
+		name := strings.TrimSuffix(rel, ".md")
+		vault.addNote(path.Base(name), id)
+		for _, alias := range append(fm.List("aliases"), fm.List("alias")...) {
+			vault.addNote(alias, id)
+		}
+		// paths take precedence over names and aliases
+		vault.notes[strings.ToLower(name)] = id
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, rel := range files {
+		p := filepath.Join(directory, filepath.FromSlash(rel))
+		fi, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		note, err := parseNoteFile(strings.TrimSuffix(path.Base(rel), ".md"), string(data))
+		if err != nil {
+			notes.Invalid(p, err)
+			continue
+		}
+		note.Source = p
+		if note.Id == "" {
+			note.Id = obsidianId(rel)
+		}
+		if note.Date.IsZero() {
+			note.Date = fi.ModTime()
+		}
+		note.Content = vault.rewrite(note.Content, path.Dir(rel), p, notes)
+		note.Tags = append(note.Tags, obsidianTags(note.Content)...)
+		if opts.directoryTags && path.Dir(rel) != "." {
+			note.Tags = append(note.Tags, strings.Split(path.Dir(rel), "/")...)
+		}
+
+		err = notes.Add(note)
+		if err != nil {
+			return err
+		}
+	}
+
+	for key, rel := range vault.attachments {
+		if key == strings.ToLower(rel) && !vault.linked[rel] {
+			notes.Ignore(filepath.Join(directory, filepath.FromSlash(rel)), "not a note")
+		}
+	}
+
+	return nil
+}
+
+// walkObsidian calls fn with the slash separated path of every file in
+// the vault, relative to directory, and its content for notes.  Hidden
+// files and directories like .obsidian and .trash are skipped.
+func walkObsidian(directory string, fn func(rel string, data []byte) error) error {
+	return filepath.Walk(directory, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(fi.Name(), ".") && p != directory {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(directory, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		var data []byte
+		if path.Ext(rel) == ".md" {
+			data, err = ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+		}
+		return fn(rel, data)
+	})
+}
+
+// obsidianId returns the id of the note at rel in the vault.
+func obsidianId(rel string) string {
+	hash := sha1.Sum([]byte(rel))
+	return "obsidian-" + hex.EncodeToString(hash[:5])
+}
+
+// addNote adds a name a note can be linked by, unless another note has
+// it already.
+func (v *obsidianVault) addNote(name, id string) {
+	key := strings.ToLower(name)
+	if _, ok := v.notes[key]; !ok {
+		v.notes[key] = id
+	}
+}
+
+// resolve returns the id of the note target refers to, or the path of the
+// attachment.  dir is the directory of the linking note, relative targets
+// are tried relative to it first.
+func (v *obsidianVault) resolve(target, dir string) (id, attachment string) {
+	target = strings.TrimSpace(target)
+	candidates := []string{path.Join(dir, target), strings.TrimPrefix(target, "/"), path.Base(target)}
+	for _, candidate := range candidates {
+		key := strings.ToLower(candidate)
+		if id, ok := v.notes[strings.TrimSuffix(key, ".md")]; ok {
+			return id, ""
+		}
+		if rel, ok := v.attachments[key]; ok {
+			return "", rel
+		}
+	}
+	return "", ""
+}
+
+// rewrite rewrites the wiki links and Markdown links to notes in content
+// to /notes/{id}.  Links to attachments and broken links are kept as they
+// are and reported for source.
+func (v *obsidianVault) rewrite(content, dir, source string, notes noteSink) string {
+	resolve := func(target string) (string, bool) {
+		id, attachment := v.resolve(target, dir)
+		switch {
+		case id != "":
+			return "/notes/" + id, true
+		case attachment != "":
+			v.linked[attachment] = true
+			notes.Ignore(source, fmt.Sprintf("attachment %q is not imported", attachment))
+		default:
+			notes.Ignore(source, fmt.Sprintf("broken link to %q", target))
+		}
+		return "", false
+	}
+
+	lines := strings.Split(content, "\n")
+	fenced := false
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			fenced = !fenced
+		}
+		if fenced {
+			continue
+		}
+
+		line = obsidianWikiLinkRe.ReplaceAllStringFunc(line, func(link string) string {
+			m := obsidianWikiLinkRe.FindStringSubmatch(link)
+			target, heading, label := m[2], m[3], m[4]
+			if target == "" {
+				// a link to a heading in the same note
+				return link
+			}
+			href, ok := resolve(target)
+			if !ok {
+				return link
+			}
+			if label == "" {
+				label = target
+				if heading != "" {
+					label += " > " + strings.TrimLeft(heading, "#^")
+				}
+			}
+			return fmt.Sprintf("[%s](%s)", label, href)
+		})
+
+		lines[i] = obsidianLinkRe.ReplaceAllStringFunc(line, func(link string) string {
+			m := obsidianLinkRe.FindStringSubmatch(link)
+			target := m[3]
+			if strings.Contains(target, ":") || strings.HasPrefix(target, "#") || strings.HasPrefix(target, "/notes/") {
+				return link
+			}
+			if i := strings.IndexByte(target, '#'); i != -1 {
+				target = target[:i]
+			}
+			unescaped, err := url.PathUnescape(target)
+			if err != nil {
+				return link
+			}
+			href, ok := resolve(unescaped)
+			if !ok {
+				return link
+			}
+			return fmt.Sprintf("[%s](%s)", m[2], href)
+		})
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// obsidianTags returns the inline #tags in content, outside of code blocks.
+func obsidianTags(content string) []string {
+	var tags []string
+	fenced := false
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			fenced = !fenced
+		}
+		if fenced {
+			continue
+		}
+		for _, m := range obsidianTagRe.FindAllStringSubmatch(line, -1) {
+			tags = append(tags, m[1])
+		}
+	}
+	return tags
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterImporter("org", Importer{
+		Description: "Org-mode files, one note per top-level heading",
+		Read:        readOrg,
+		Key:         dedupeByContent,
+		Policy:      conflictSkip,
+	})
+}
+
+var (
+	orgTagsRe      = regexp.MustCompile(`\s+:([^\s:]+:)+\s*$`)
+	orgKeywordRe   = regexp.MustCompile(`^(TODO|DONE|NEXT|WAITING|CANCELED|CANCELLED)\s+`)
+	orgPriorityRe  = regexp.MustCompile(`^\[#[A-Z]\]\s+`)
+	orgLinkRe      = regexp.MustCompile(`\[\[([^\]]+)\](?:\[([^\]]*)\])?\]`)
+	orgTimestampRe = regexp.MustCompile(`[<\[](\d{4}-\d{2}-\d{2})(?: [^\d\s>\]]+)?(?: (\d{1,2}:\d{2}))?[^>\]]*[>\]]`)
+	orgPropertyRe  = regexp.MustCompile(`^\s*:([^:\s]+):\s*(.*)$`)
+)
+
+// readOrg reads the notes in an Org-mode file, or in all .org files in a
+// directory.
+//
+// Each top-level heading becomes a note, with the Org tags of the heading
+// (and #+FILETAGS) as tags.  The date is taken from the CREATED property,
+// the first timestamp or the modification time of the file, in that order.
+// A link in the heading is used as the url.
+func readOrg(path string, opts importOptions, notes noteSink) error {
+	return filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() {
+			if strings.HasPrefix(fi.Name(), ".") && p != path {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(p) != ".org" {
+			if p != path {
+				notes.Ignore(p, "not an Org file")
+				return nil
+			}
+		}
+
+		return readOrgFile(p, fi.ModTime(), notes)
+	})
+}
+
+func readOrgFile(path string, modTime time.Time, notes noteSink) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var fileTags []string
+	var heading string
+	var headingLine int
+	var body []string
+	flush := func() error {
+		if heading == "" {
+			return nil
+		}
+
+		note, err := parseOrgHeading(heading, body)
+		source := fmt.Sprintf("%s:%d", path, headingLine)
+		if err != nil {
+			notes.Invalid(source, err)
+			return nil
+		}
+		note.Source = source
+		note.Tags = append(note.Tags, fileTags...)
+		if note.Date.IsZero() {
+			note.Date = modTime
+		}
+		return notes.Add(note)
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineNumber += 1
+
+		switch {
+		case strings.HasPrefix(line, "* "):
+			err = flush()
+			if err != nil {
+				return err
+			}
+			heading, headingLine, body = line[2:], lineNumber, nil
+		case heading == "":
+			if strings.HasPrefix(strings.ToUpper(line), "#+FILETAGS:") {
+				fileTags = orgTags(line[len("#+FILETAGS:"):])
+			}
+		default:
+			body = append(body, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return flush()
+}
+
+func parseOrgHeading(heading string, body []string) (importNote, error) {
+	note := importNote{}
+
+	heading = strings.TrimSpace(heading)
+	if tags := orgTagsRe.FindString(heading); tags != "" {
+		note.Tags = orgTags(tags)
+		heading = strings.TrimSpace(heading[:len(heading)-len(tags)])
+	}
+	heading = orgKeywordRe.ReplaceAllString(heading, "")
+	heading = orgPriorityRe.ReplaceAllString(heading, "")
+
+	if link := orgLinkRe.FindStringSubmatch(heading); link != nil {
+		u, err := url.Parse(link[1])
+		if err != nil {
+			return note, err
+		}
+		note.URL = u
+
+		description := link[2]
+		if description == "" {
+			description = link[1]
+		}
+		heading = strings.Replace(heading, link[0], description, 1)
+	}
+	note.Title = heading
+
+	// the properties drawer directly after the heading
+	properties := map[string]string{}
+	start := 0
+	for start < len(body) && orgPlanningLine(body[start]) {
+		start += 1
+	}
+	if start < len(body) && strings.TrimSpace(body[start]) == ":PROPERTIES:" {
+		for i := start + 1; i < len(body); i++ {
+			if strings.TrimSpace(body[i]) == ":END:" {
+				body = append(body[:start:start], body[i+1:]...)
+				break
+			}
+			if m := orgPropertyRe.FindStringSubmatch(body[i]); m != nil {
+				properties[strings.ToUpper(m[1])] = strings.TrimSpace(m[2])
+			}
+		}
+	}
+
+	note.Id = properties["ID"]
+	if rawURL := properties["URL"]; rawURL != "" && note.URL == nil {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return note, err
+		}
+		note.URL = u
+	}
+
+	var err error
+	if created := properties["CREATED"]; created != "" {
+		note.Date, err = parseOrgTimestamp(created)
+		if err != nil {
+			return note, err
+		}
+	} else {
+		for _, line := range append([]string{heading}, body...) {
+			if orgTimestampRe.MatchString(line) {
+				note.Date, err = parseOrgTimestamp(line)
+				if err != nil {
+					return note, err
+				}
+				break
+			}
+		}
+	}
+
+	note.Content = orgToMarkdown(body)
+	return note, nil
+}
+
+// orgPlanningLine returns whether line is a SCHEDULED, DEADLINE or CLOSED
+// line, which come before the properties drawer.
+func orgPlanningLine(line string) bool {
+	line = strings.TrimSpace(line)
+	return strings.HasPrefix(line, "SCHEDULED:") || strings.HasPrefix(line, "DEADLINE:") ||
+		strings.HasPrefix(line, "CLOSED:")
+}
+
+// orgTags parses tags in the ":foo:bar:" syntax.
+func orgTags(s string) []string {
+	tags := make([]string, 0)
+	for _, tag := range strings.Split(strings.TrimSpace(s), ":") {
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// parseOrgTimestamp parses the first timestamp in s, like
+// "[2016-01-02 Sat 15:04]" or "<2016-01-02 Sat>".
+func parseOrgTimestamp(s string) (time.Time, error) {
+	m := orgTimestampRe.FindStringSubmatch(s)
+	if m == nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+
+	if m[2] == "" {
+		return time.ParseInLocation("2006-01-02", m[1], time.Local)
+	}
+	return time.ParseInLocation("2006-01-02 15:04", m[1]+" "+m[2], time.Local)
+}
+
+// orgToMarkdown converts the body of a heading to Markdown.  Only
+// subheadings and links are converted, the rest is kept as is.
+func orgToMarkdown(lines []string) string {
+	converted := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if stars := len(line) - len(strings.TrimLeft(line, "*")); stars > 1 && strings.HasPrefix(line[stars:], " ") {
+			line = strings.Repeat("#", stars) + line[stars:]
+		}
+
+		line = orgLinkRe.ReplaceAllStringFunc(line, func(link string) string {
+			m := orgLinkRe.FindStringSubmatch(link)
+			if m[2] == "" {
+				return "<" + m[1] + ">"
+			}
+			return "[" + m[2] + "](" + m[1] + ")"
+		})
+		converted = append(converted, line)
+	}
+	content := strings.Trim(strings.Join(converted, "\n"), "\n")
+	if content == "" {
+		return ""
+	}
+	return content + "\n"
+}
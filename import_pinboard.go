@@ -3,34 +3,49 @@ package main
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
-	"github.com/heyLu/mu"
-	"github.com/heyLu/mu/connection"
-	"github.com/heyLu/mu/database"
-	tx "github.com/heyLu/mu/transactor"
+	"io"
 	"net/url"
 	"os"
 	"strings"
 	"time"
 )
 
-type pinboardPosts struct {
-	XMLName xml.Name       `xml:"posts"`
-	User    string         `xml:"user,attr"`
-	Posts   []pinboardPost `xml:"post"`
+// pinboardPost is a bookmark in Pinboard's XML or JSON export.
+type pinboardPost struct {
+	XMLName xml.Name  `xml:"post" json:"-"`
+	Title   string    `xml:"description,attr" json:"description"`
+	Content string    `xml:"extended,attr" json:"extended"`
+	Date    time.Time `xml:"time,attr" json:"time"`
+	URL     string    `xml:"href,attr" json:"href"`
+	Tags    string    `xml:"tag,attr" json:"tags"`
+	Hash    string    `xml:"hash,attr" json:"hash"`
+	Shared  string    `xml:"shared,attr" json:"shared"`
+	ToRead  string    `xml:"toread,attr" json:"toread"`
 }
 
-type pinboardPost struct {
-	XMLName xml.Name  `xml:"post"`
-	Title   string    `xml:"description,attr"`
-	Content string    `xml:"extended,attr"`
-	Date    time.Time `xml:"time,attr"`
-	URL     string    `xml:"href,attr"`
-	Tags    string    `xml:"tag,attr"`
+func init() {
+	RegisterImporter("pinboard", Importer{
+		Description: "Pinboard XML export",
+		Read:        readPinboard,
+		Key:         dedupeByURL,
+		Policy:      conflictSkip,
+	})
+	RegisterImporter("pinboard-json", Importer{
+		Description: "Pinboard JSON export",
+		Read:        readPinboardJSON,
+		Key:         dedupeByURL,
+		Policy:      conflictSkip,
+	})
 }
 
-func ImportFromPinboard(pinboardXMLPath string, conn connection.Connection) error {
+// readPinboard reads bookmarks from a Pinboard XML export.
+//
+// The export is read post by post, so that large exports can be imported
+// in batches.
+func readPinboard(pinboardXMLPath string, opts importOptions, notes noteSink) error {
 	f, err := os.Open(pinboardXMLPath)
 	if err != nil {
 		return err
@@ -38,81 +53,78 @@ func ImportFromPinboard(pinboardXMLPath string, conn connection.Connection) erro
 	defer f.Close()
 
 	decoder := xml.NewDecoder(f)
-	var posts pinboardPosts
-	err = decoder.Decode(&posts)
-	if err != nil {
-		return err
-	}
-
-	fmt.Println(len(posts.Posts))
-
 	n := 0
-	nextId := func() int {
-		n -= 1
-		return n
-	}
-
-	tagIds := map[string]int{}
-	tagId := -1000000
-	nextTagId := func(tag string) int {
-		if id, ok := tagIds[tag]; ok {
-			return id
+	for {
+		t, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
 		}
 
-		tagId -= 1
-		tagIds[tag] = tagId
-		return tagId
-	}
-
-	txData := make([]tx.TxDatum, 0)
-	for _, post := range posts.Posts {
-		txDatum := tx.TxMap{
-			Id: mu.Id(mu.Tempid(mu.DbPartUser, nextId())),
-			Attributes: map[database.Keyword][]tx.Value{
-				mu.Keyword("note", "id"):      []tx.Value{tx.NewValue(generateId())},
-				mu.Keyword("note", "title"):   []tx.Value{tx.NewValue(post.Title)},
-				mu.Keyword("note", "content"): []tx.Value{tx.NewValue(post.Content)},
-				mu.Keyword("note", "date"):    []tx.Value{tx.NewValue(post.Date)},
-			},
+		start, ok := t.(xml.StartElement)
+		if !ok || start.Name.Local != "post" {
+			continue
 		}
 
-		if post.URL != "" {
-			u, err := url.Parse(post.URL)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-			} else {
-				txDatum.Attributes[mu.Keyword("note", "url")] = []tx.Value{tx.NewValue(u)}
-			}
+		var post pinboardPost
+		err = decoder.DecodeElement(&post, &start)
+		if err != nil {
+			return err
 		}
 
-		tags := strings.Split(post.Tags, " ")
-		tagValues := make([]tx.Value, len(tags))
-		for i, tag := range tags {
-			id := mu.Id(mu.Tempid(mu.DbPartUser, nextTagId(tag)))
-			tagValues[i] = tx.NewValue(id)
+		n += 1
+		err = addPinboardPost(notes, post, n)
+		if err != nil {
+			return err
 		}
-		txDatum.Attributes[mu.Keyword("note", "tags")] = tagValues
+	}
+
+	return nil
+}
 
-		txData = append(txData, txDatum)
+// readPinboardJSON reads bookmarks from a Pinboard JSON export.
+func readPinboardJSON(pinboardJSONPath string, opts importOptions, notes noteSink) error {
+	f, err := os.Open(pinboardJSONPath)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
 
-	for tag, id := range tagIds {
-		txDatum := tx.Datum{
-			Op: tx.Assert,
-			E:  mu.Id(mu.Tempid(mu.DbPartUser, id)),
-			A:  mu.Keyword("tag", "name"),
-			V:  tx.NewValue(tag),
+	n := 0
+	return decodeJSONArray(f, func(decoder *json.Decoder) error {
+		var post pinboardPost
+		err := decoder.Decode(&post)
+		if err != nil {
+			return err
 		}
-		txData = append(txData, txDatum)
+
+		n += 1
+		return addPinboardPost(notes, post, n)
+	})
+}
+
+func addPinboardPost(notes noteSink, post pinboardPost, n int) error {
+	note := importNote{
+		Title:   post.Title,
+		Content: post.Content,
+		Date:    post.Date,
+		Tags:    strings.Fields(post.Tags),
+		ToRead:  post.ToRead == "yes",
+		Private: post.Shared == "no",
+		Source:  fmt.Sprintf("post %d", n),
 	}
 
-	txRes, err := mu.Transact(conn, txData)
-	if err != nil {
-		return err
+	if post.URL != "" {
+		u, err := url.Parse(post.URL)
+		if err != nil {
+			notes.Invalid(note.Source, err)
+			return nil
+		}
+		note.URL = u
 	}
 
-	fmt.Println("added", len(txRes.Datoms), "datoms")
-	return nil
+	return notes.Add(note)
 }
 
 func generateId() string {
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterImporter("twitter", Importer{
+		Description: "tweets and likes from a Twitter/X archive",
+		Read:        readTwitter,
+		Key:         dedupeByURL,
+		Policy:      conflictSkip,
+	})
+}
+
+type twitterTweet struct {
+	Tweet struct {
+		Id        string `json:"id_str"`
+		FullText  string `json:"full_text"`
+		CreatedAt string `json:"created_at"`
+		Entities  struct {
+			Hashtags []struct {
+				Text string `json:"text"`
+			} `json:"hashtags"`
+			URLs []struct {
+				URL         string `json:"url"`
+				ExpandedURL string `json:"expanded_url"`
+			} `json:"urls"`
+		} `json:"entities"`
+	} `json:"tweet"`
+}
+
+type twitterLike struct {
+	Like struct {
+		TweetId     string `json:"tweetId"`
+		FullText    string `json:"fullText"`
+		ExpandedURL string `json:"expandedUrl"`
+	} `json:"like"`
+}
+
+type twitterAccount struct {
+	Account struct {
+		Username string `json:"username"`
+	} `json:"account"`
+}
+
+// twitterTimeFormat is the format of created_at in the archive.
+const twitterTimeFormat = "Mon Jan 02 15:04:05 -0700 2006"
+
+var (
+	twitterHashtagRe = regexp.MustCompile(`(?:^|\s)#(\w+)`)
+	twitterTweetsRe  = regexp.MustCompile(`^tweets?(-part\d+)?\.js$`)
+	twitterLikesRe   = regexp.MustCompile(`^like(-part\d+)?\.js$`)
+)
+
+// readTwitter reads the tweets and likes in the data directory of a
+// Twitter/X archive.
+//
+// Tweets become notes with the tweet as content, its url as note/url and
+// its hashtags as tags.  Likes are tagged "liked" in addition, and are
+// dated by their tweet id, or by the archive file for tweets from before
+// late 2010.
+func readTwitter(path string, opts importOptions, notes noteSink) error {
+	dataDir := path
+	if fi, err := os.Stat(filepath.Join(path, "data")); err == nil && fi.IsDir() {
+		dataDir = filepath.Join(path, "data")
+	}
+
+	username := ""
+	var accounts []twitterAccount
+	err := readTwitterJS(filepath.Join(dataDir, "account.js"), &accounts)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(accounts) > 0 {
+		username = accounts[0].Account.Username
+	}
+
+	tweetFiles, err := twitterFiles(dataDir, twitterTweetsRe)
+	if err != nil {
+		return err
+	}
+	for _, tweetFile := range tweetFiles {
+		var tweets []twitterTweet
+		err = readTwitterJS(tweetFile, &tweets)
+		if err != nil {
+			return err
+		}
+
+		for i, tweet := range tweets {
+			source := fmt.Sprintf("%s: tweet %d", tweetFile, i+1)
+			note, err := parseTweet(tweet, username)
+			if err != nil {
+				notes.Invalid(source, err)
+				continue
+			}
+			note.Source = source
+
+			err = notes.Add(note)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	likeFiles, err := twitterFiles(dataDir, twitterLikesRe)
+	if err != nil {
+		return err
+	}
+	for _, likeFile := range likeFiles {
+		var likes []twitterLike
+		err = readTwitterJS(likeFile, &likes)
+		if err != nil {
+			return err
+		}
+		fi, err := os.Stat(likeFile)
+		if err != nil {
+			return err
+		}
+
+		for i, like := range likes {
+			source := fmt.Sprintf("%s: like %d", likeFile, i+1)
+			note, err := parseLike(like)
+			if err != nil {
+				notes.Invalid(source, err)
+				continue
+			}
+			note.Source = source
+			if note.Date.IsZero() {
+				// the date of likes of old tweets is unknown
+				note.Date = fi.ModTime()
+			}
+
+			err = notes.Add(note)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(tweetFiles) == 0 && len(likeFiles) == 0 {
+		return fmt.Errorf("no tweets or likes found in %s", dataDir)
+	}
+	return nil
+}
+
+// twitterFiles returns the files in dataDir whose names match re.  Large
+// archives split tweets and likes into several parts.
+func twitterFiles(dataDir string, re *regexp.Regexp) ([]string, error) {
+	fis, err := ioutil.ReadDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0)
+	for _, fi := range fis {
+		if re.MatchString(fi.Name()) {
+			files = append(files, filepath.Join(dataDir, fi.Name()))
+		}
+	}
+	return files, nil
+}
+
+// readTwitterJS reads the JSON in a file of the archive, which is
+// assigned to a variable like "window.YTD.tweets.part0 = [...]".
+func readTwitterJS(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if i := bytes.IndexByte(data, '='); i != -1 && !bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		data = data[i+1:]
+	}
+	return json.Unmarshal(data, v)
+}
+
+func parseTweet(tweet twitterTweet, username string) (importNote, error) {
+	t := tweet.Tweet
+
+	// the text in the archive is HTML-escaped
+	text := html.UnescapeString(t.FullText)
+	for _, u := range t.Entities.URLs {
+		if u.ExpandedURL != "" {
+			text = strings.Replace(text, u.URL, u.ExpandedURL, -1)
+		}
+	}
+
+	note := importNote{
+		Title:   tweetTitle(text),
+		Content: text + "\n",
+	}
+
+	var err error
+	note.Date, err = time.Parse(twitterTimeFormat, t.CreatedAt)
+	if err != nil {
+		return note, err
+	}
+
+	note.URL, err = url.Parse(tweetURL(username, t.Id))
+	if err != nil {
+		return note, err
+	}
+
+	for _, hashtag := range t.Entities.Hashtags {
+		note.Tags = append(note.Tags, hashtag.Text)
+	}
+
+	return note, nil
+}
+
+func parseLike(like twitterLike) (importNote, error) {
+	l := like.Like
+
+	text := html.UnescapeString(l.FullText)
+	note := importNote{
+		Title:   tweetTitle(text),
+		Content: text + "\n",
+		Tags:    []string{"liked"},
+	}
+
+	// likes have no date, but the ids of tweets since late 2010 contain
+	// the time they were tweeted at
+	var err error
+	note.Date, err = tweetIdTime(l.TweetId)
+	if err != nil {
+		return note, err
+	}
+
+	rawURL := l.ExpandedURL
+	if rawURL == "" {
+		rawURL = tweetURL("", l.TweetId)
+	}
+	note.URL, err = url.Parse(rawURL)
+	if err != nil {
+		return note, err
+	}
+
+	for _, m := range twitterHashtagRe.FindAllStringSubmatch(text, -1) {
+		note.Tags = append(note.Tags, m[1])
+	}
+
+	return note, nil
+}
+
+func tweetURL(username, id string) string {
+	if username == "" {
+		return "https://twitter.com/i/web/status/" + id
+	}
+	return "https://twitter.com/" + username + "/status/" + id
+}
+
+// tweetIdTime returns the time encoded in a tweet id (a "snowflake"), or
+// the zero time for ids from before snowflakes were introduced, which
+// contain no time.
+func tweetIdTime(id string) (time.Time, error) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if n < 1<<22 {
+		return time.Time{}, nil
+	}
+
+	const twitterEpoch = 1288834974657
+	ms := (n >> 22) + twitterEpoch
+	return time.Unix(0, ms*int64(time.Millisecond)), nil
+}
+
+// tweetTitle returns the start of the first line of text, because tweets
+// have no title.
+func tweetTitle(text string) string {
+	title := strings.TrimSpace(text)
+	if i := strings.IndexByte(title, '\n'); i != -1 {
+		title = title[:i]
+	}
+
+	const maxLength = 80
+	if len([]rune(title)) > maxLength {
+		title = string([]rune(title)[:maxLength-1]) + "…"
+	}
+	return title
+}
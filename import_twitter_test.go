@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTweetIdTime(t *testing.T) {
+	tests := []struct {
+		id   string
+		time time.Time
+		err  bool
+	}{
+		{"1212092628029698048", time.Date(2019, 12, 31, 19, 26, 16, 771000000, time.UTC), false},
+		// ids from before snowflakes contain no time
+		{"20", time.Time{}, false},
+		{"4194303", time.Time{}, false},
+		{"not a number", time.Time{}, true},
+	}
+
+	for _, test := range tests {
+		tm, err := tweetIdTime(test.id)
+		if (err != nil) != test.err || !tm.Equal(test.time) {
+			t.Errorf("%q: got %s, %v", test.id, tm, err)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"golang.org/x/net/html"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterImporter("webpage", Importer{
+		Description: "saved web pages (.html, SingleFile or \"Save page as\")",
+		Read:        readWebPages,
+		Key:         dedupeByURL,
+		Policy:      conflictSkip,
+	})
+}
+
+var (
+	// singleFileRe matches the comment SingleFile adds to saved pages.
+	singleFileRe = regexp.MustCompile(`(?m)^\s*(url|saved date):\s*(.*?)\s*$`)
+	// savedFromRe matches the "saved from" comment browsers add to saved
+	// pages.
+	savedFromRe = regexp.MustCompile(`saved from url=\(\d+\)(\S+)`)
+)
+
+// singleFileDateFormat is the format of the "saved date" in SingleFile
+// comments, without the name of the time zone that follows it.
+const singleFileDateFormat = "Mon Jan 02 2006 15:04:05 GMT-0700"
+
+// webPageBoilerplate are the elements that are dropped from the text of a
+// page.
+var webPageBoilerplate = map[string]bool{
+	"nav": true, "header": true, "footer": true, "aside": true, "form": true,
+	"button": true, "select": true, "iframe": true, "menu": true,
+}
+
+// readWebPages reads a saved HTML page, or all pages in a directory.
+//
+// The url is the canonical url of the page, or the one recorded by
+// SingleFile or the browser when saving it.  The content is the readable
+// text of the page, converted to Markdown: the main article if the page
+// marks one up, without navigation, headers and footers.  The date is the
+// date the page was saved, if known, or its modification time.
+func readWebPages(path string, opts importOptions, notes noteSink) error {
+	return filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() {
+			// the resources of pages saved with "Save page as"
+			if (strings.HasPrefix(fi.Name(), ".") || strings.HasSuffix(fi.Name(), "_files")) && p != path {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(p))
+		if ext != ".html" && ext != ".htm" && p != path {
+			notes.Ignore(p, "not an HTML file")
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		note, err := parseWebPage(f)
+		if err != nil {
+			notes.Invalid(p, err)
+			return nil
+		}
+		note.Source = p
+		if note.Title == "" {
+			note.Title = strings.TrimSuffix(fi.Name(), filepath.Ext(fi.Name()))
+		}
+		if note.Date.IsZero() {
+			note.Date = fi.ModTime()
+		}
+		return notes.Add(note)
+	})
+}
+
+// webPage collects the metadata of a page while it is parsed.
+type webPage struct {
+	title, ogTitle, h1  string
+	canonical, ogURL    string
+	savedURL            string
+	saved               time.Time
+	article, main, body *html.Node
+	articleText         int
+}
+
+// parseWebPage parses a saved page.
+//
+// Saved pages are rarely valid XML (SingleFile leaves attribute values
+// unquoted, for example), so they are parsed as HTML.
+func parseWebPage(r io.Reader) (importNote, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return importNote{}, err
+	}
+
+	page := &webPage{}
+	page.visit(doc)
+
+	note := importNote{
+		Title:   firstNonEmpty(page.ogTitle, page.title, page.h1),
+		Content: page.text(doc),
+		Date:    page.saved,
+	}
+
+	rawURL := firstNonEmpty(page.canonical, page.ogURL, page.savedURL)
+	if rawURL != "" {
+		note.URL, err = url.Parse(rawURL)
+		if err != nil {
+			return note, err
+		}
+		if !note.URL.IsAbs() {
+			return note, fmt.Errorf("relative url %q", rawURL)
+		}
+	}
+
+	return note, nil
+}
+
+// visit collects the metadata and the main parts of the page in n and
+// its descendants.
+func (page *webPage) visit(n *html.Node) {
+	switch n.Type {
+	case html.CommentNode:
+		page.comment(n.Data)
+	case html.ElementNode:
+		switch n.Data {
+		case "title":
+			if page.title == "" {
+				page.title = nodeText(n)
+			}
+		case "h1":
+			if page.h1 == "" {
+				page.h1 = nodeText(n)
+			}
+		case "link":
+			if strings.EqualFold(nodeAttr(n, "rel"), "canonical") {
+				page.canonical = nodeAttr(n, "href")
+			}
+		case "meta":
+			switch strings.ToLower(nodeAttr(n, "property")) {
+			case "og:url":
+				page.ogURL = nodeAttr(n, "content")
+			case "og:title":
+				page.ogTitle = nodeAttr(n, "content")
+			}
+		case "article":
+			// pages with several articles are usually lists of excerpts,
+			// the longest one is the most likely to be the content
+			if length := len(nodeText(n)); length > page.articleText {
+				page.article, page.articleText = n, length
+			}
+		case "body":
+			page.body = n
+		}
+		if page.main == nil && (n.Data == "main" || nodeAttr(n, "role") == "main") {
+			page.main = n
+		}
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		page.visit(c)
+	}
+}
+
+// comment reads the url and date of pages saved with SingleFile or a
+// browser from comment.
+func (page *webPage) comment(comment string) {
+	if m := savedFromRe.FindStringSubmatch(comment); m != nil {
+		page.savedURL = m[1]
+		return
+	}
+
+	if !strings.Contains(comment, "SingleFile") {
+		return
+	}
+	for _, m := range singleFileRe.FindAllStringSubmatch(comment, -1) {
+		switch m[1] {
+		case "url":
+			page.savedURL = m[2]
+		case "saved date":
+			date := m[2]
+			if i := strings.Index(date, " ("); i != -1 {
+				date = date[:i]
+			}
+			saved, err := time.Parse(singleFileDateFormat, date)
+			if err == nil {
+				page.saved = saved
+			}
+		}
+	}
+}
+
+// text converts the main part of the page to Markdown: the longest
+// article, the main element or the body, in that order.
+func (page *webPage) text(doc *html.Node) string {
+	n := doc
+	for _, candidate := range []*html.Node{page.article, page.main, page.body} {
+		if candidate != nil {
+			n = candidate
+			break
+		}
+	}
+
+	w := &markdownWriter{}
+	writeWebNode(w, n)
+	return w.String()
+}
+
+// writeWebNode writes n and its descendants to w, without the
+// boilerplate elements.
+func writeWebNode(w *markdownWriter, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.text(n.Data)
+		return
+	case html.ElementNode:
+		if webPageBoilerplate[n.Data] {
+			return
+		}
+		attrs := make([]xml.Attr, 0, len(n.Attr))
+		for _, a := range n.Attr {
+			attrs = append(attrs, xml.Attr{Name: xml.Name{Local: a.Key}, Value: a.Val})
+		}
+		w.start(n.Data, attrs)
+		defer w.end(n.Data)
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		writeWebNode(w, c)
+	}
+}
+
+// nodeText returns the text in n, with whitespace collapsed.
+func nodeText(n *html.Node) string {
+	buf := new(bytes.Buffer)
+	var collect func(n *html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			buf.WriteString(n.Data)
+			buf.WriteString(" ")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+	collect(n)
+	return strings.Join(strings.Fields(buf.String()), " ")
+}
+
+// nodeAttr returns the value of the attribute name of n, or "".
+func nodeAttr(n *html.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWebPage(t *testing.T) {
+	page := `<!DOCTYPE html> <html><!--
+ Page saved with SingleFile
+ url: https://example.com/post
+ saved date: Tue Mar 05 2024 10:11:12 GMT+0100 (Central European Standard Time)
+--><head><meta charset=utf-8><title>A post</title><script>if (a < b) x()</script>
+<body><nav><a href=/home>Home</a></nav>
+<article><h1>Heading</h1><p>First <a href=/x>link</a> and <img src=a.png alt=pic>
+<p>Second<blockquote>quoted</blockquote><ul><li>one<li>two</ul></article>
+<footer>footer</footer>`
+
+	note, err := parseWebPage(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note.Title != "A post" {
+		t.Errorf("got title %q", note.Title)
+	}
+	if note.URL == nil || note.URL.String() != "https://example.com/post" {
+		t.Errorf("got url %v", note.URL)
+	}
+	if saved := time.Date(2024, 3, 5, 9, 11, 12, 0, time.UTC); !note.Date.Equal(saved) {
+		t.Errorf("got date %s", note.Date)
+	}
+	content := "# Heading\n\nFirst [link](/x) and ![pic](a.png)\n\nSecond\n\n> quoted\n\n- one\n- two\n"
+	if note.Content != content {
+		t.Errorf("got content %q, expected %q", note.Content, content)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// scriptRe matches script and style elements, which are removed before
+// parsing because they may contain unescaped "<".
+var scriptRe = regexp.MustCompile(`(?is)<script\b.*?</script\s*>|<style\b.*?</style\s*>`)
+
+// htmlToMarkdown converts HTML (or ENML) to Markdown.
+//
+// Only the common elements are converted: paragraphs, headings, lists,
+// links, emphasis, code, quotes and checkboxes.  Scripts, styles and
+// embedded media are dropped, other elements are reduced to their text.
+func htmlToMarkdown(html string) (string, error) {
+	html = scriptRe.ReplaceAllString(html, "")
+	decoder := xml.NewDecoder(strings.NewReader(html))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	w := &markdownWriter{}
+	for {
+		t, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			w.start(strings.ToLower(t.Name.Local), t.Attr)
+		case xml.EndElement:
+			w.end(strings.ToLower(t.Name.Local))
+		case xml.CharData:
+			w.text(string(t))
+		}
+	}
+
+	return w.String(), nil
+}
+
+type markdownList struct {
+	ordered bool
+	n       int
+}
+
+type markdownWriter struct {
+	buf bytes.Buffer
+
+	// newlines is the number of line breaks to write before the next
+	// text, 2 starts a new paragraph.
+	newlines int
+	// space is whether a space should be written before the next text.
+	space bool
+
+	skip  int
+	pre   int
+	quote int
+	// lineQuote is the quote level of the last line written
+	lineQuote int
+	lists     []markdownList
+	hrefs     []string
+	inCell    bool
+}
+
+// String returns the Markdown written so far.
+func (w *markdownWriter) String() string {
+	return strings.TrimSpace(w.buf.String()) + "\n"
+}
+
+func (w *markdownWriter) block(newlines int) {
+	if newlines > w.newlines {
+		w.newlines = newlines
+	}
+	w.space = false
+}
+
+// close writes the closing marker s of an inline element, directly after
+// the preceding text.
+func (w *markdownWriter) close(s string) {
+	space := w.space
+	w.space = false
+	w.raw(s)
+	w.space = space
+}
+
+// raw writes s after any pending line breaks, without collapsing its
+// whitespace.
+func (w *markdownWriter) raw(s string) {
+	lineStart := w.buf.Len() == 0 || w.newlines > 0
+	if w.buf.Len() > 0 && w.newlines > 0 {
+		blankQuote := w.quote
+		if w.lineQuote < blankQuote {
+			blankQuote = w.lineQuote
+		}
+		for i := 0; i < w.newlines; i++ {
+			if i > 0 {
+				w.buf.WriteString(strings.Repeat(">", blankQuote))
+			}
+			w.buf.WriteByte('\n')
+		}
+	} else if w.space {
+		w.buf.WriteByte(' ')
+	}
+	if lineStart {
+		w.buf.WriteString(strings.Repeat("> ", w.quote))
+		w.buf.WriteString(strings.Repeat("   ", w.listIndent()))
+	}
+	w.newlines = 0
+	w.space = false
+	w.lineQuote = w.quote
+	w.buf.WriteString(s)
+}
+
+func (w *markdownWriter) listIndent() int {
+	if len(w.lists) == 0 {
+		return 0
+	}
+	return len(w.lists) - 1
+}
+
+func (w *markdownWriter) text(s string) {
+	if w.skip > 0 {
+		return
+	}
+
+	if w.pre > 0 {
+		w.raw(s)
+		return
+	}
+
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		if s != "" && w.buf.Len() > 0 {
+			w.space = true
+		}
+		return
+	}
+
+	leadingSpace := strings.TrimLeft(s, " \t\r\n") != s
+	trailingSpace := strings.TrimRight(s, " \t\r\n") != s
+	if leadingSpace && w.newlines == 0 && w.buf.Len() > 0 {
+		w.space = true
+	}
+	w.raw(strings.Join(words, " "))
+	w.space = trailingSpace
+}
+
+func attr(attrs []xml.Attr, name string) string {
+	for _, a := range attrs {
+		if strings.EqualFold(a.Name.Local, name) {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func (w *markdownWriter) start(name string, attrs []xml.Attr) {
+	if markdownSkipped[name] {
+		w.skip += 1
+	}
+	if w.skip > 0 {
+		return
+	}
+
+	switch name {
+	case "p", "div", "section", "article", "header", "footer", "table", "dl", "figure":
+		w.block(2)
+	case "br":
+		if w.pre > 0 {
+			w.raw("\n")
+		} else {
+			w.block(1)
+		}
+	case "hr":
+		w.block(2)
+		w.raw("---")
+		w.block(2)
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		w.block(2)
+		w.raw(strings.Repeat("#", int(name[1]-'0')) + " ")
+	case "ul", "ol":
+		if len(w.lists) == 0 {
+			w.block(2)
+		}
+		w.lists = append(w.lists, markdownList{ordered: name == "ol"})
+	case "li":
+		w.block(1)
+		if len(w.lists) == 0 {
+			w.raw("- ")
+			break
+		}
+		list := &w.lists[len(w.lists)-1]
+		list.n += 1
+		if list.ordered {
+			w.raw(fmt.Sprintf("%d. ", list.n))
+		} else {
+			w.raw("- ")
+		}
+	case "blockquote":
+		w.block(2)
+		w.quote += 1
+	case "pre":
+		w.block(2)
+		w.raw("```\n")
+		w.pre += 1
+	case "code", "tt", "kbd":
+		if w.pre == 0 {
+			w.raw("`")
+		}
+	case "strong", "b":
+		w.raw("**")
+	case "em", "i":
+		w.raw("*")
+	case "a":
+		href := attr(attrs, "href")
+		if strings.HasPrefix(href, "javascript:") {
+			href = ""
+		}
+		w.hrefs = append(w.hrefs, href)
+		if href != "" {
+			w.raw("[")
+		}
+	case "img":
+		if src := attr(attrs, "src"); src != "" && !strings.HasPrefix(src, "data:") {
+			w.raw(fmt.Sprintf("![%s](%s)", attr(attrs, "alt"), src))
+		}
+	case "en-todo":
+		w.block(1)
+		if attr(attrs, "checked") == "true" {
+			w.raw("- [x] ")
+		} else {
+			w.raw("- [ ] ")
+		}
+	case "tr":
+		w.block(1)
+		w.inCell = false
+	case "td", "th":
+		if w.inCell {
+			w.raw(" | ")
+		}
+		w.inCell = true
+	case "dt", "dd":
+		w.block(1)
+	}
+}
+
+// markdownSkipped are the elements whose content is dropped.
+var markdownSkipped = map[string]bool{
+	"script": true, "style": true, "head": true, "title": true, "noscript": true,
+	"template": true, "en-crypt": true, "svg": true,
+}
+
+func (w *markdownWriter) end(name string) {
+	if w.skip > 0 {
+		if markdownSkipped[name] {
+			w.skip -= 1
+		}
+		return
+	}
+
+	switch name {
+	case "p", "div", "section", "article", "header", "footer", "table", "dl", "figure",
+		"h1", "h2", "h3", "h4", "h5", "h6":
+		w.block(2)
+	case "ul", "ol":
+		if len(w.lists) > 0 {
+			w.lists = w.lists[:len(w.lists)-1]
+		}
+		if len(w.lists) == 0 {
+			w.block(2)
+		}
+	case "li":
+		w.block(1)
+	case "blockquote":
+		if w.quote > 0 {
+			w.quote -= 1
+		}
+		w.block(2)
+	case "pre":
+		if w.pre > 0 {
+			w.pre -= 1
+			if !bytes.HasSuffix(w.buf.Bytes(), []byte("\n")) {
+				w.buf.WriteByte('\n')
+			}
+			w.buf.WriteString("```")
+			w.block(2)
+		}
+	case "code", "tt", "kbd":
+		if w.pre == 0 {
+			w.close("`")
+		}
+	case "strong", "b":
+		w.close("**")
+	case "em", "i":
+		w.close("*")
+	case "a":
+		if len(w.hrefs) > 0 {
+			href := w.hrefs[len(w.hrefs)-1]
+			w.hrefs = w.hrefs[:len(w.hrefs)-1]
+			if href != "" {
+				w.close("](" + href + ")")
+			}
+		}
+	}
+}
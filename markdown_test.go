@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestHTMLToMarkdown(t *testing.T) {
+	tests := []struct {
+		html, markdown string
+	}{
+		{"<p>Hello <b>bold</b> and <i>italic</i></p>", "Hello **bold** and *italic*\n"},
+		{"<p>one</p><p>two</p>", "one\n\ntwo\n"},
+		{"<h2>Title</h2><p>text</p>", "## Title\n\ntext\n"},
+		{`<a href="https://example.com">a link</a>`, "[a link](https://example.com)\n"},
+		{`<a href="javascript:void(0)">no link</a>`, "no link\n"},
+		{"<ul><li>one</li><li>two</li></ul>", "- one\n- two\n"},
+		{"<ol><li>one</li><li>two</li></ol>", "1. one\n2. two\n"},
+		{"<blockquote>quoted</blockquote>", "> quoted\n"},
+		{"<blockquote><p>one</p><p>two</p></blockquote>", "> one\n>\n> two\n"},
+		{"<ul><li>one<ul><li>nested</li></ul></li></ul>", "- one\n   - nested\n"},
+		{"<pre>x := 1\ny := 2</pre>", "```\nx := 1\ny := 2\n```\n"},
+		{"<p>use <code>go test</code></p>", "use `go test`\n"},
+		{`<img src="a.png" alt="pic"/>`, "![pic](a.png)\n"},
+		{"<p>before<script>if (a < b) {}</script> after</p>", "before after\n"},
+		{"<p>a &amp; b &lt; c</p>", "a & b < c\n"},
+		{`<en-note><en-todo checked="true"/>done<br/><en-todo/>open</en-note>`, "- [x] done\n- [ ] open\n"},
+	}
+
+	for _, test := range tests {
+		markdown, err := htmlToMarkdown(test.html)
+		if err != nil {
+			t.Errorf("%q: %s", test.html, err)
+			continue
+		}
+		if markdown != test.markdown {
+			t.Errorf("%q: got %q, expected %q", test.html, markdown, test.markdown)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/heyLu/mu"
+	"github.com/heyLu/mu/database"
+	"sort"
+)
+
+// MigrateDatabase copies the database at fromUrl to a new database at
+// toUrl, which may use a different store.
+//
+// The data is copied as by dump and restore, keeping the transactions the
+// current datoms were asserted in if requested.  Retracted values are not
+// copied.
+// The notes keep their ids, which is checked along with the number of
+// datoms before the migration is reported as done.  The source database
+// is not changed.
+func MigrateDatabase(fromUrl, toUrl string, transactions bool) error {
+	if fromUrl == toUrl {
+		return fmt.Errorf("cannot migrate %s to itself", fromUrl)
+	}
+
+	// connect without creating the database if it does not exist
+	from, err := mu.Connect(fromUrl)
+	if err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	_, err = dumpDatabase(buf, transactions, from.Db())
+	if err != nil {
+		return err
+	}
+	d, err := readDump(buf)
+	if err != nil {
+		return err
+	}
+
+	to, txs, err := restoreDatabase(d, toUrl)
+	if err != nil {
+		return err
+	}
+
+	missing := missingNotes(from.Db(), to.Db())
+	if len(missing) > 0 {
+		return fmt.Errorf("verifying %s: %d notes are missing, e.g. %s", toUrl, len(missing), missing[0])
+	}
+
+	fmt.Printf("migrated %d datoms in %d transactions from %s to %s\n", len(d.datoms), txs, fromUrl, toUrl)
+	fmt.Printf("use -db %q from now on\n", toUrl)
+	return nil
+}
+
+// missingNotes returns the ids of the notes in from that are not in to.
+func missingNotes(from, to *database.Database) []string {
+	missing := make([]string, 0)
+	iter := from.Aevt().Datoms2(mu.Keyword("note", "id"), nil, nil)
+	for datom := iter.Next(); datom != nil; datom = iter.Next() {
+		id := fmt.Sprint(datom.V().Val())
+		if findNote(to, id) == -1 {
+			missing = append(missing, id)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
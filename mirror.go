@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"github.com/heyLu/mu"
+	"github.com/heyLu/mu/connection"
+	"github.com/heyLu/mu/database"
+	tx "github.com/heyLu/mu/transactor"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// noteMirror keeps a directory of Markdown files in sync with the notes,
+// in the format written by ExportToDirectory.
+type noteMirror struct {
+	lock sync.Mutex
+	dir  string
+	conn connection.Connection
+	// files maps the entity ids of notes to their file names, so that
+	// the files can be renamed or removed with the notes.
+	files map[int]string
+}
+
+// newNoteMirror writes all notes to dir and removes the files of notes
+// that do not exist anymore.
+func newNoteMirror(dir string, conn connection.Connection) (*noteMirror, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &noteMirror{dir: dir, conn: conn, files: map[int]string{}}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	db := conn.Db()
+	written := 0
+	iter := db.Aevt().Datoms2(mu.Keyword("note", "id"), nil, nil)
+	for datom := iter.Next(); datom != nil; datom = iter.Next() {
+		post := Post{db.Entity(datom.E())}
+		changed, err := writeNoteFile(dir, post)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			written += 1
+		}
+		m.files[datom.E()] = noteFileName(post)
+	}
+
+	removed, err := removeStaleNoteFiles(dir, db)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("mirror: wrote", written, "notes to", dir+",", "removed", removed)
+	return m, nil
+}
+
+// Update writes the files of the notes changed by a transaction, and
+// removes the files of notes that were retracted.  Notes with a tag that
+// was changed are written as well.
+func (m *noteMirror) Update(result *tx.TxResult) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	db := m.conn.Db()
+	entities := map[int]bool{}
+	for _, datom := range result.Datoms {
+		entities[datom.E()] = true
+	}
+
+	for e := range entities {
+		if _, isTag := db.Entity(e).Get(mu.Keyword("tag", "name")).(string); isTag {
+			iter := db.Vaet().Datoms2(mu.Id(e), mu.Keyword("note", "tags"), nil)
+			for datom := iter.Next(); datom != nil; datom = iter.Next() {
+				entities[datom.E()] = true
+			}
+		}
+	}
+
+	var errs []string
+	for e := range entities {
+		err := m.updateNote(db, e)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func (m *noteMirror) updateNote(db *database.Database, e int) error {
+	old := m.files[e]
+	post := Post{db.Entity(e)}
+	if _, isNote := post.Get(mu.Keyword("note", "id")).(string); !isNote {
+		if old == "" {
+			return nil
+		}
+		delete(m.files, e)
+		return removeIfExists(path.Join(m.dir, old))
+	}
+
+	name := noteFileName(post)
+	if old != "" && old != name {
+		err := removeIfExists(path.Join(m.dir, old))
+		if err != nil {
+			return err
+		}
+	}
+	m.files[e] = name
+
+	_, err := writeNoteFile(m.dir, post)
+	return err
+}
+
+func removeIfExists(p string) error {
+	err := os.Remove(p)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
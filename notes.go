@@ -7,8 +7,9 @@ import (
 	"github.com/heyLu/mu/connection"
 	_ "github.com/heyLu/mu/store/bolt"
 	_ "github.com/heyLu/mu/store/sqlite"
-	"io/ioutil"
 	"os"
+	"strings"
+	"time"
 )
 
 var config struct {
@@ -30,58 +31,197 @@ func main() {
 	cmd := flag.Arg(0)
 	args := flag.Args()[1:]
 	switch cmd {
-	case "import-pinboard":
+	case "import":
+		runImport(cmd, args)
+	case "export-json":
 		conn := ConnectOrInit(config.dbUrl)
-		err := ImportFromPinboard(args[0], conn)
+		err := ExportToJSON(args[0], conn)
 		if err != nil {
 			panic(err)
 		}
-	case "import-directory":
+	case "export-directory":
 		conn := ConnectOrInit(config.dbUrl)
-		err := ImportFromDirectory(args[0], conn)
+		err := ExportToDirectory(args[0], conn)
 		if err != nil {
 			panic(err)
 		}
-	case "import-json":
+	case "export-bookmarks-html":
+		flags := flag.NewFlagSet(cmd, flag.ExitOnError)
+		tag := flags.String("tag", "", "Only export notes with this tag")
+		flags.Parse(args)
+
+		conn := ConnectOrInit(config.dbUrl)
+		err := ExportToBookmarksHTML(flags.Arg(0), *tag, conn)
+		if err != nil {
+			panic(err)
+		}
+	case "export-bibtex":
+		flags := flag.NewFlagSet(cmd, flag.ExitOnError)
+		tag := flags.String("tag", "", "Export all notes with this tag instead of the ones imported from BibTeX")
+		flags.Parse(args)
+
 		conn := ConnectOrInit(config.dbUrl)
-		err := ImportFromJSON(args[0], conn)
+		err := ExportToBibTeX(flags.Arg(0), *tag, conn)
+		if err != nil {
+			panic(err)
+		}
+	case "dump":
+		flags := flag.NewFlagSet(cmd, flag.ExitOnError)
+		transactions := flags.Bool("transactions", false, "Record the transaction each datom was asserted in, so that restore groups them the same way (retracted values are never dumped)")
+		flags.Parse(args)
+
+		conn := ConnectOrInit(config.dbUrl)
+		err := DumpDatabase(flags.Arg(0), *transactions, conn)
+		if err != nil {
+			panic(err)
+		}
+	case "restore":
+		err := RestoreDatabase(args[0], config.dbUrl)
+		if err != nil {
+			panic(err)
+		}
+	case "migrate":
+		flags := flag.NewFlagSet(cmd, flag.ExitOnError)
+		from := flags.String("from", config.dbUrl, "The database to migrate")
+		to := flags.String("to", "", "The new database, e.g. bolt://notes.db")
+		transactions := flags.Bool("transactions", true, "Keep the transactions the current data was asserted in (retracted values are not copied)")
+		flags.Parse(args)
+
+		if *to == "" {
+			fmt.Fprintf(os.Stderr, "Usage: %s %s -from <url> -to <url>\n", os.Args[0], cmd)
+			os.Exit(1)
+		}
+
+		err := MigrateDatabase(*from, *to, *transactions)
 		if err != nil {
 			panic(err)
 		}
 	case "server":
+		flags := flag.NewFlagSet(cmd, flag.ExitOnError)
+		flags.StringVar(&serverConfig.backup.dir, "backup-dir", "", "Dump the database to this directory regularly")
+		flags.DurationVar(&serverConfig.backup.interval, "backup-interval", 24*time.Hour, "The time between backups")
+		flags.IntVar(&serverConfig.backup.keepDaily, "keep-daily", 7, "The number of days to keep the last backup of")
+		flags.IntVar(&serverConfig.backup.keepWeekly, "keep-weekly", 4, "The number of weeks to keep the last backup of")
+		flags.StringVar(&serverConfig.mirrorDir, "mirror-dir", "", "Keep a Markdown file for each note in this directory")
+		flags.Parse(args)
+
+		if serverConfig.backup.interval <= 0 {
+			fmt.Fprintln(os.Stderr, "-backup-interval must be positive")
+			os.Exit(1)
+		}
+
 		conn := ConnectOrInit(config.dbUrl)
 		err := RunServer(conn)
 		if err != nil {
 			panic(err)
 		}
 	default:
+		// import-<format> is short for import -format=<format>
+		if strings.HasPrefix(cmd, "import-") {
+			runImport(cmd, args)
+			return
+		}
+
 		fmt.Fprintf(os.Stderr, "unknown command '%s'\n", cmd)
 		os.Exit(1)
 	}
 }
 
-func ConnectOrInit(dbUrl string) connection.Connection {
-	isNew, err := mu.CreateDatabase(dbUrl)
+func runImport(cmd string, args []string) {
+	format, opts, args := parseImportFlags(cmd, args)
+	conn := ConnectOrInit(config.dbUrl)
+	err := Import(format, args[0], conn, opts)
 	if err != nil {
 		panic(err)
 	}
+}
 
-	conn, err := mu.Connect(dbUrl)
+func parseImportFlags(cmd string, args []string) (string, importOptions, []string) {
+	flags := flag.NewFlagSet(cmd, flag.ExitOnError)
+	format := strings.TrimPrefix(cmd, "import-")
+	if cmd == "import" {
+		flags.StringVar(&format, "format", "", "The format to import ("+strings.Join(ImportFormats(), ", ")+")")
+	}
+	onConflict := flags.String("on-conflict", "", "What to do with notes that exist already (skip, overwrite or merge-tags)")
+	var opts importOptions
+	flags.BoolVar(&opts.dryRun, "dry-run", false, "Report what would be imported without changing the database")
+	flags.BoolVar(&opts.directoryTags, "dir-tags", false, "Use subdirectory names as tags (directory format)")
+	flags.BoolVar(&opts.kindleBooks, "per-book", false, "Import one note per book instead of per highlight (kindle format)")
+	flags.BoolVar(&opts.keepTrashed, "include-trashed", false, "Import trashed notes as well (keep format)")
+	flags.IntVar(&opts.batchSize, "batch-size", 1000, "The number of notes to import per transaction, 0 for a single transaction")
+	flags.BoolVar(&opts.resume, "resume", false, "Continue after the last batch committed by an interrupted import")
+	flags.Parse(args)
+
+	var err error
+	opts.onConflict, err = parseConflictPolicy(*onConflict)
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
-	if isNew {
-		schema, err := ioutil.ReadFile("schema.edn")
-		if err != nil {
-			panic(err)
+	if flags.NArg() < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [<flags>] <path>\n", os.Args[0], cmd)
+		os.Exit(1)
+	}
+
+	if _, ok := importers[format]; !ok {
+		fmt.Fprintf(os.Stderr, "unknown import format '%s', available formats:\n", format)
+		for _, name := range ImportFormats() {
+			fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, importers[name].Description)
 		}
+		os.Exit(1)
+	}
 
-		_, err = mu.TransactString(conn, string(schema))
+	if opts.batchSize > 0 && !opts.dryRun {
+		opts.progressPath, err = importProgressPath(config.dbUrl, format, flags.Arg(0))
 		if err != nil {
-			panic(err)
+			fmt.Fprintln(os.Stderr, "Warning: cannot record the progress of the import:", err)
 		}
 	}
 
+	return format, opts, flags.Args()
+}
+
+func ConnectOrInit(dbUrl string) connection.Connection {
+	conn, _, err := connectOrInit(dbUrl, readSchema)
+	if err != nil {
+		panic(err)
+	}
 	return conn
 }
+
+// connectOrInit connects to the database at dbUrl, creating it with the
+// schema returned by schema if it does not exist yet.  Attributes of the
+// schema that an existing database lacks are installed.
+func connectOrInit(dbUrl string, schema func() (string, error)) (conn connection.Connection, isNew bool, err error) {
+	isNew, err = mu.CreateDatabase(dbUrl)
+	if err != nil {
+		return nil, false, err
+	}
+
+	conn, err = mu.Connect(dbUrl)
+	if err != nil {
+		return nil, false, err
+	}
+
+	s, err := schema()
+	if err != nil && !isNew {
+		fmt.Fprintln(os.Stderr, "Warning: not checking the schema:", err)
+		return conn, false, nil
+	} else if err != nil {
+		return nil, true, err
+	}
+	if !isNew {
+		// databases created with an older schema get the attributes that
+		// were added since
+		s = missingSchema(conn.Db(), s)
+	}
+	if s != "" {
+		_, err = mu.TransactString(conn, s)
+		if err != nil {
+			return nil, isNew, err
+		}
+	}
+
+	return conn, isNew, nil
+}
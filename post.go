@@ -2,10 +2,12 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/heyLu/mu"
 	"github.com/heyLu/mu/database"
 	"net/url"
+	"strings"
 	"time"
 )
 
@@ -13,6 +15,43 @@ type Post struct {
 	database.Entity
 }
 
+// AllPosts returns all notes in the database, oldest first.
+func AllPosts(db *database.Database) []Post {
+	iter := db.Avet().Datoms2(mu.Keyword("note", "date"), nil, nil)
+
+	posts := make([]Post, 0)
+	for datom := iter.Next(); datom != nil; datom = iter.Next() {
+		posts = append(posts, Post{db.Entity(datom.E())})
+	}
+	return posts
+}
+
+// TaggedPosts returns the notes tagged with the tag called name, or false
+// if there is no such tag.
+func TaggedPosts(db *database.Database, name string) ([]Post, bool) {
+	tagId := db.Entid(mu.LookupRef(mu.Keyword("tag", "name"), name))
+	if tagId == -1 {
+		return nil, false
+	}
+	iter := db.Vaet().Datoms2(mu.Id(tagId), mu.Keyword("note", "tags"), nil)
+
+	posts := make([]Post, 0)
+	for datom := iter.Next(); datom != nil; datom = iter.Next() {
+		posts = append(posts, Post{db.Entity(datom.E())})
+	}
+	return posts, true
+}
+
+// checkNoteId returns an error if id cannot be used as a note id.  Ids
+// appear in urls and file names, so they must not contain path
+// separators or "..".
+func checkNoteId(id string) error {
+	if strings.ContainsAny(id, `/\`) || strings.Contains(id, "..") {
+		return fmt.Errorf("invalid note id %q", id)
+	}
+	return nil
+}
+
 func (p Post) Id() string {
 	return p.Get(mu.Keyword("note", "id")).(string)
 }
@@ -29,6 +68,13 @@ func (p Post) Date() time.Time {
 	return p.Get(mu.Keyword("note", "date")).(time.Time)
 }
 
+// Modified returns the date the note was last changed, or the zero time
+// if it is not known.
+func (p Post) Modified() time.Time {
+	modified, _ := p.Get(mu.Keyword("note", "modified")).(time.Time)
+	return modified
+}
+
 func (p Post) URL() *url.URL {
 	u := p.Get(mu.Keyword("note", "url"))
 	if u == nil {
@@ -37,6 +83,16 @@ func (p Post) URL() *url.URL {
 	return u.(*url.URL)
 }
 
+func (p Post) ToRead() bool {
+	toRead, _ := p.Get(mu.Keyword("note", "toread")).(bool)
+	return toRead
+}
+
+func (p Post) Private() bool {
+	private, _ := p.Get(mu.Keyword("note", "private")).(bool)
+	return private
+}
+
 func (p Post) Tags() []Tag {
 	rawTags := p.Get(mu.Keyword("note", "tags")).([]interface{})
 	if len(rawTags) == 0 {
@@ -53,13 +109,22 @@ func (p Post) Tags() []Tag {
 func (p Post) MarshalJSON() ([]byte, error) {
 	buf := new(bytes.Buffer)
 	fmt.Fprintf(buf, "{")
-	fmt.Fprintf(buf, "\"id\": %q, ", p.Id())
-	fmt.Fprintf(buf, "\"title\": %q, ", p.Title())
-	fmt.Fprintf(buf, "\"content\": %q, ", p.Content())
-	fmt.Fprintf(buf, "\"date\": \"%s\"", p.Date().Format(time.RFC3339))
+	fmt.Fprintf(buf, "\"id\": %s, ", jsonString(p.Id()))
+	fmt.Fprintf(buf, "\"title\": %s, ", jsonString(p.Title()))
+	fmt.Fprintf(buf, "\"content\": %s, ", jsonString(p.Content()))
+	fmt.Fprintf(buf, "\"date\": \"%s\"", p.Date().Format(time.RFC3339Nano))
+	if modified := p.Modified(); !modified.IsZero() {
+		fmt.Fprintf(buf, " ,\"modified\": \"%s\"", modified.Format(time.RFC3339Nano))
+	}
 	u := p.URL()
 	if u != nil {
-		fmt.Fprintf(buf, " ,\"url\": %q", u)
+		fmt.Fprintf(buf, " ,\"url\": %s", jsonString(u.String()))
+	}
+	if p.ToRead() {
+		fmt.Fprint(buf, " ,\"toread\": true")
+	}
+	if p.Private() {
+		fmt.Fprint(buf, " ,\"private\": true")
 	}
 	tags := p.Tags()
 	if tags != nil {
@@ -70,7 +135,7 @@ func (p Post) MarshalJSON() ([]byte, error) {
 				fmt.Fprint(buf, ", ")
 			}
 			first = false
-			fmt.Fprintf(buf, "%q", tag)
+			fmt.Fprintf(buf, "%s", jsonString(tag.Name()))
 		}
 		fmt.Fprintf(buf, "]")
 	}
@@ -78,6 +143,12 @@ func (p Post) MarshalJSON() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// jsonString quotes s as a JSON string.  (%q is not quite JSON.)
+func jsonString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
 type Tag struct {
 	database.Entity
 }
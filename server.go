@@ -13,12 +13,16 @@ import (
 	"strings"
 	"time"
 
-	"./renderable"
+	"notes/renderable"
 )
 
 var serverConfig struct {
-	conn connection.Connection
-	addr string
+	conn   connection.Connection
+	addr   string
+	backup backupConfig
+	// mirrorDir is the directory the notes are mirrored to, if set.
+	mirrorDir string
+	mirror    *noteMirror
 }
 
 func init() {
@@ -28,6 +32,18 @@ func init() {
 func RunServer(conn connection.Connection) error {
 	serverConfig.conn = conn
 
+	if serverConfig.backup.dir != "" {
+		go runBackups(conn, serverConfig.backup)
+	}
+
+	if serverConfig.mirrorDir != "" {
+		mirror, err := newNoteMirror(serverConfig.mirrorDir, conn)
+		if err != nil {
+			return err
+		}
+		serverConfig.mirror = mirror
+	}
+
 	http.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 		if req.URL.Path == "/" {
 			http.Redirect(w, req, "/new", http.StatusSeeOther)
@@ -69,6 +85,10 @@ func RunServer(conn connection.Connection) error {
 	http.HandleFunc("/tags/", renderable.HandleRequest(GetTag))
 	http.HandleFunc("/tags", renderable.HandleRequest(ListTags))
 	http.HandleFunc("/tags.json", renderable.HandleRequest(ListTags))
+	http.HandleFunc("/bookmarks.html", GetBookmarksHTML)
+	http.HandleFunc("/notes.bib", GetBibTeX)
+	http.HandleFunc("/admin/status", renderable.HandleRequest(GetStatus))
+	http.HandleFunc("/admin/status.json", renderable.HandleRequest(GetStatus))
 
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
 
@@ -141,6 +161,11 @@ func NewPost(w http.ResponseWriter, req *http.Request) {
 	if id == "" {
 		id = generateId()
 	}
+	err = checkNoteId(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	title := req.FormValue("title")
 	content := req.FormValue("content")
 	rawDate := req.FormValue("date")
@@ -187,7 +212,7 @@ func NewPost(w http.ResponseWriter, req *http.Request) {
 		n -= 1
 	}
 
-	_, err = mu.Transact(serverConfig.conn, txData)
+	err = transact(txData)
 	if err != nil {
 		fmt.Fprint(os.Stderr, "Error: ", err)
 		status := http.StatusInternalServerError
@@ -198,6 +223,27 @@ func NewPost(w http.ResponseWriter, req *http.Request) {
 	http.Redirect(w, req, "/notes", http.StatusSeeOther)
 }
 
+// transact transacts txData and updates the mirror, if there is one.
+// Errors writing the mirror are only logged, because the transaction
+// succeeded.
+//
+// All changes made by the server must use it, so that the mirror stays
+// up to date.
+func transact(txData []tx.TxDatum) error {
+	result, err := mu.Transact(serverConfig.conn, txData)
+	if err != nil {
+		return err
+	}
+
+	if serverConfig.mirror != nil {
+		err = serverConfig.mirror.Update(result)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error: mirror:", err)
+		}
+	}
+	return nil
+}
+
 func ListPosts(w http.ResponseWriter, req *http.Request) (interface{}, error) {
 	db := serverConfig.conn.Db()
 	iter := db.Avet().Datoms2(mu.Keyword("note", "date"), nil, nil)
@@ -269,17 +315,10 @@ func GetTag(w http.ResponseWriter, req *http.Request) (interface{}, error) {
 	}
 	tagName := parts[2]
 
-	db := serverConfig.conn.Db()
-	tagId := db.Entid(mu.LookupRef(mu.Keyword("tag", "name"), tagName))
-	if tagId == -1 {
+	posts, ok := TaggedPosts(serverConfig.conn.Db(), tagName)
+	if !ok {
 		return renderable.RenderableStatus(http.StatusNotFound), nil
 	}
-	iter := db.Vaet().Datoms2(mu.Id(tagId), mu.Keyword("note", "tags"), nil)
-
-	posts := make([]Post, 0)
-	for datom := iter.Next(); datom != nil; datom = iter.Next() {
-		posts = append(posts, Post{db.Entity(datom.E())})
-	}
 	sort.Sort(sort.Reverse(postsByDate(posts)))
 
 	n := fromQueryInt(req, "n", 100)
@@ -443,6 +482,12 @@ var listPostsTemplateStr = `<!doctype html>
 			margin-bottom: 0;
 		}
 
+		.post .flag {
+			font-size: smaller;
+			padding: 0 0.5ex;
+			background-color: #eee;
+		}
+
 		.post .tags a {
 			text-decoration: none;
 			color: black;
@@ -486,6 +531,8 @@ var listPostsTemplateStr = `<!doctype html>
 			<h1>{{ .Title }}</h1>
 			{{ end }}
 			<time>{{ .Date }}</time>
+			{{ if .ToRead }}<span class="flag">to read</span>{{ end }}
+			{{ if .Private }}<span class="flag">private</span>{{ end }}
 			{{ if .Tags }}<div class="tags">{{ .Tags | joinTags }}</div>{{ end }}
 			<pre>{{ .Content }}</pre>
 		</div>